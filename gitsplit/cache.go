@@ -6,13 +6,16 @@ import (
 	"github.com/libgit2/git2go"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
 type CachePoolInterface interface {
 	SaveItem(item *CacheItem) error
 	GetItem(referenceName string, split Split) (*CacheItem, error)
+	Prune(referenceNames []string, split Split) error
 	Load() error
 	Dump() error
 	Push()
@@ -31,6 +34,10 @@ func (c *NullCachePool) GetItem(referenceName string, split Split) (*CacheItem,
 	}, nil
 }
 
+func (c *NullCachePool) Prune(referenceNames []string, split Split) error {
+	return nil
+}
+
 func (c *NullCachePool) Load() error {
 	return nil
 }
@@ -61,26 +68,63 @@ func NewCachePool(workingSpacePath string, remote *GitRemote) *CachePool {
 }
 
 func getFlagName(referenceName string, split Split) string {
-	return fmt.Sprintf("%s-%s", utils.Hash(referenceName), utils.Hash(strings.Join(split.Prefixes, "-")))
+	return fmt.Sprintf("%s-%s", utils.Hash(referenceName), split.cacheKey())
 }
 
+// liteDatabaseRegexp matches the splitsh.db of the working copies of the
+// lite backend, stored in the cache as "splitsh-<working copy>.db".
+var liteDatabaseRegexp = regexp.MustCompile(`^splitsh-(\w+)\.db$`)
+
 func (c *CachePool) Load() error {
-	if err := c.remote.FetchFile("splitsh", "splitsh.db", filepath.Join(c.workingSpacePath, "splitsh.db")); err != nil {
+	fileNames, err := c.remote.ListFiles("splitsh")
+	if err != nil {
 		return errors.Wrap(err, "failed to fetch cache")
 	}
+	for _, fileName := range fileNames {
+		matches := liteDatabaseRegexp.FindStringSubmatch(fileName)
+		if matches == nil {
+			continue
+		}
+		workingCopyPath := filepath.Join(c.workingSpacePath, "lite", matches[1])
+		if err := os.MkdirAll(workingCopyPath, 0755); err != nil {
+			return errors.Wrapf(err, "failed to create working copy %s", workingCopyPath)
+		}
+		if err := c.remote.FetchFile("splitsh", fileName, filepath.Join(workingCopyPath, "splitsh.db")); err != nil {
+			return errors.Wrap(err, "failed to fetch cache")
+		}
+	}
+	if err := c.remote.FetchFile("splitsh", "gitsplit.map", filepath.Join(c.workingSpacePath, "gitsplit.map")); err != nil {
+		return errors.Wrap(err, "failed to fetch commit map")
+	}
+	if err := c.remote.FetchFile("splitsh", "gitsplit.splits", filepath.Join(c.workingSpacePath, "gitsplit.splits")); err != nil {
+		return errors.Wrap(err, "failed to fetch split map")
+	}
 	log.Info("Cache loaded")
 
 	return nil
 }
 
 func (c *CachePool) Dump() error {
-	if !utils.FileExists(filepath.Join(c.workingSpacePath, "splitsh.db")) {
-		return nil
-	}
-
-	if err := c.remote.PushFile("splitsh.db", filepath.Join(c.workingSpacePath, "splitsh.db"), "Update splitsh cache", "splitsh"); err != nil {
+	databasePaths, err := filepath.Glob(filepath.Join(c.workingSpacePath, "lite", "*", "splitsh.db"))
+	if err != nil {
 		return errors.Wrap(err, "failed to save cache")
 	}
+	for _, databasePath := range databasePaths {
+		fileName := fmt.Sprintf("splitsh-%s.db", filepath.Base(filepath.Dir(databasePath)))
+		if err := c.remote.PushFile(fileName, databasePath, "Update splitsh cache", "splitsh"); err != nil {
+			return errors.Wrap(err, "failed to save cache")
+		}
+	}
+	if utils.FileExists(filepath.Join(c.workingSpacePath, "gitsplit.map")) {
+		if err := c.remote.PushFile("gitsplit.map", filepath.Join(c.workingSpacePath, "gitsplit.map"), "Update splitsh cache", "splitsh"); err != nil {
+			return errors.Wrap(err, "failed to save commit map")
+		}
+	}
+	if utils.FileExists(filepath.Join(c.workingSpacePath, "gitsplit.splits")) {
+		if err := c.remote.PushFile("gitsplit.splits", filepath.Join(c.workingSpacePath, "gitsplit.splits"), "Update splitsh cache", "splitsh"); err != nil {
+			return errors.Wrap(err, "failed to save split map")
+		}
+	}
 	log.Info("Cache dumped")
 
 	return nil
@@ -138,6 +182,41 @@ func (c *CachePool) GetItem(referenceName string, split Split) (*CacheItem, erro
 	}, nil
 }
 
+// Prune removes the cached items of the split whose reference is not part of
+// referenceNames anymore.
+func (c *CachePool) Prune(referenceNames []string, split Split) error {
+	knownFlagNames := []string{}
+	for _, referenceName := range referenceNames {
+		knownFlagNames = append(knownFlagNames, getFlagName(referenceName, split))
+	}
+
+	references, err := c.remote.GetReferences()
+	if err != nil {
+		return errors.Wrap(err, "failed to prune cache")
+	}
+
+	splitSuffix := "-" + split.cacheKey()
+	for _, reference := range references {
+		var flagName string
+		if strings.HasPrefix(reference.Alias, "source-") {
+			flagName = strings.TrimPrefix(reference.Alias, "source-")
+		} else if strings.HasPrefix(reference.Alias, "target-") {
+			flagName = strings.TrimPrefix(reference.Alias, "target-")
+		} else {
+			continue
+		}
+		if !strings.HasSuffix(flagName, splitSuffix) || utils.InArray(knownFlagNames, flagName) {
+			continue
+		}
+
+		if err := c.remote.RemoveReference(reference.Alias); err != nil {
+			return errors.Wrapf(err, "failed to prune cache reference %s", reference.Alias)
+		}
+	}
+
+	return nil
+}
+
 func (c *CacheItem) IsFresh(reference Reference) bool {
 	if c.sourceId == nil {
 		return false
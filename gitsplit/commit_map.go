@@ -0,0 +1,40 @@
+package gitsplit
+
+import (
+	"fmt"
+	"github.com/libgit2/git2go"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+)
+
+// CommitMap records which source commit each split commit comes from. The
+// file is stored in the cache next to splitsh.db.
+type CommitMap struct {
+	filePath string
+	mutex    *sync.Mutex
+}
+
+func NewCommitMap(filePath string) *CommitMap {
+	return &CommitMap{
+		filePath: filePath,
+		mutex:    &sync.Mutex{},
+	}
+}
+
+func (m *CommitMap) Add(splitId *git.Oid, sourceId *git.Oid) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	file, err := os.OpenFile(m.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open commit map %s", m.filePath)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintf(file, "%s %s\n", splitId, sourceId); err != nil {
+		return errors.Wrapf(err, "failed to write commit map %s", m.filePath)
+	}
+
+	return nil
+}
@@ -1,47 +1,285 @@
 package gitsplit
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/gosimple/slug"
 	"github.com/jderusse/gitsplit/utils"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
+	"io"
 	"io/ioutil"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 type StringCollection []string
 type PrefixCollection StringCollection
+type PushPolicy string
+type Backend string
+
+const (
+	PushPolicyForce           PushPolicy = "force"
+	PushPolicyFastForwardOnly PushPolicy = "fast-forward-only"
+	PushPolicyForceWithLease  PushPolicy = "force-with-lease"
+)
+
+const (
+	BackendLite   Backend = "lite"
+	BackendNative Backend = "native"
+)
+
+type RenameRule struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
 
 type Split struct {
-	Prefixes PrefixCollection `yaml:"prefix"`
-	Targets  StringCollection `yaml:"target"`
+	Name           string            `yaml:"name"`
+	Prefixes       PrefixCollection  `yaml:"prefix"`
+	Targets        StringCollection  `yaml:"target"`
+	PushPolicy     PushPolicy        `yaml:"push_policy"`
+	Prune          bool              `yaml:"prune"`
+	Origins        StringCollection  `yaml:"origins"`
+	ExcludeOrigins StringCollection  `yaml:"exclude_origins"`
+	Rename         []RenameRule      `yaml:"rename"`
+	TagPrefix      string            `yaml:"tag_prefix"`
+	Backend        Backend           `yaml:"backend"`
+	MessageFilters []MessageFilter   `yaml:"message_filters"`
+	OriginTrailer  bool              `yaml:"origin_trailer"`
+	Include        StringCollection  `yaml:"include"`
+	Exclude        StringCollection  `yaml:"exclude"`
+	Overlay        string            `yaml:"overlay"`
+	Vars           map[string]string `yaml:"vars"`
+
+	// line of the split in the config file
+	line int
 }
 
 type Config struct {
-	CacheUrl   *GitUrl  `yaml:"cache_url"`
-	ProjectUrl *GitUrl  `yaml:"project_url"`
-	Splits     []Split  `yaml:"splits"`
-	Origins    []string `yaml:"origins"`
+	CacheUrl       *GitUrl          `yaml:"cache_url"`
+	ProjectUrl     *GitUrl          `yaml:"project_url"`
+	Splits         []Split          `yaml:"splits"`
+	Origins        []string         `yaml:"origins"`
+	ExcludeOrigins []string         `yaml:"exclude_origins"`
+	PushPolicy     PushPolicy       `yaml:"push_policy"`
+	Backend        Backend          `yaml:"backend"`
+	Include        StringCollection `yaml:"include"`
+	Discover       []Split          `yaml:"discover"`
+	DiscoverBranch string           `yaml:"discover_branch"`
+}
+
+// matchPatterns evaluates a list of patterns where entries prefixed by "!"
+// exclude what they match. A list made only of exclusions matches everything else.
+func matchPatterns(patterns []string, match func(pattern string) bool) bool {
+	matched := false
+	hasInclusion := false
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			if match(pattern[1:]) {
+				return false
+			}
+			continue
+		}
+
+		hasInclusion = true
+		if !matched && match(pattern) {
+			matched = true
+		}
+	}
+
+	return matched || !hasInclusion
+}
+
+func matchReference(patterns []string, alias string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+
+	return matchPatterns(patterns, func(pattern string) bool {
+		return regexp.MustCompile(pattern).MatchString(alias)
+	})
+}
+
+// DisplayName returns the name of the split, or its prefixes when the split
+// is not named.
+func (s *Split) DisplayName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+
+	return strings.Join(s.Prefixes, ", ")
+}
+
+// cacheKey identifies the split in caches: two splits sharing the same key
+// produce the same split commits.
+func (s *Split) cacheKey() string {
+	key := strings.Join(s.Prefixes, "-")
+	if len(s.MessageFilters) > 0 {
+		key += fmt.Sprintf("-%v", s.MessageFilters)
+	}
+	if s.OriginTrailer {
+		key += "-" + originTrailer
+	}
+	if len(s.Include) > 0 {
+		key += "-include:" + strings.Join(s.Include, ",")
+	}
+	if len(s.Exclude) > 0 {
+		key += "-exclude:" + strings.Join(s.Exclude, ",")
+	}
+	if s.Overlay != "" {
+		key += "-overlay:" + s.Overlay
+	}
+
+	return utils.Hash(key)
+}
+
+// hasSharedTargets tells whether several prefixes are moved to the same
+// directory of the split.
+func (s *Split) hasSharedTargets() bool {
+	seen := []string{}
+	for _, prefix := range s.Prefixes {
+		_, to := parsePrefix(prefix)
+		to = strings.Trim(to, "/")
+		if utils.InArray(seen, to) {
+			return true
+		}
+		seen = append(seen, to)
+	}
+
+	return false
+}
+
+// requiresNativeBackend tells whether the split rewrites commits in a way
+// splitsh/lite does not support.
+func (s *Split) requiresNativeBackend() bool {
+	return len(s.MessageFilters) > 0 || s.OriginTrailer || len(s.Include) > 0 || len(s.Exclude) > 0 || s.Overlay != "" || s.hasSharedTargets()
+}
+
+func isTag(reference Reference) bool {
+	return strings.HasPrefix(reference.ShortName, "tags/")
+}
+
+// MatchReference tells whether the reference should be split by this split.
+// When the split defines a tag prefix, only the tags carrying it are selected
+// and origins are matched against the tag name without its prefix.
+func (s *Split) MatchReference(reference Reference) bool {
+	alias := reference.Alias
+	if s.TagPrefix != "" && isTag(reference) {
+		if !strings.HasPrefix(alias, s.TagPrefix) {
+			return false
+		}
+		alias = strings.TrimPrefix(alias, s.TagPrefix)
+	}
+
+	return s.MatchAlias(alias)
+}
+
+// MatchAlias tells whether the alias matches the origins of the split.
+func (s *Split) MatchAlias(alias string) bool {
+	return matchReference(s.Origins, alias) && !matchReference(s.ExcludeOrigins, alias)
+}
+
+// TargetReference returns the reference to push in targets, once the tag
+// prefix has been stripped and the rename rules applied. The first matching
+// rule wins.
+func (s *Split) TargetReference(reference Reference) Reference {
+	alias := reference.Alias
+	if s.TagPrefix != "" && isTag(reference) {
+		alias = strings.TrimPrefix(alias, s.TagPrefix)
+	}
+
+	for _, rule := range s.Rename {
+		ruleRegexp := regexp.MustCompile(rule.From)
+		if ruleRegexp.MatchString(alias) {
+			alias = ruleRegexp.ReplaceAllString(alias, rule.To)
+			break
+		}
+	}
+
+	if alias == reference.Alias {
+		return reference
+	}
+
+	return Reference{
+		Alias:     alias,
+		ShortName: strings.TrimSuffix(reference.ShortName, reference.Alias) + alias,
+		Name:      strings.TrimSuffix(reference.Name, reference.Alias) + alias,
+		Id:        reference.Id,
+	}
+}
+
+func (s *RenameRule) UnmarshalYAML(node *yaml.Node) error {
+	var raw struct {
+		From string `yaml:"from"`
+		To   string `yaml:"to"`
+	}
+	if err := decodeNode(node, &raw); err != nil {
+		return err
+	}
+
+	if raw.From == "" || raw.To == "" {
+		return newYamlError(node, "A rename rule requires both `from` and `to`")
+	}
+	if _, err := regexp.Compile(raw.From); err != nil {
+		return newYamlError(node, "Invalid rename rule %s: %s", raw.From, err)
+	}
+
+	*s = RenameRule(raw)
+
+	return nil
 }
 
-func (s *PrefixCollection) UnmarshalYAML(unmarshal func(interface{}) error) error {
+func (s *Backend) UnmarshalYAML(node *yaml.Node) error {
+	var raw string
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+
+	switch Backend(raw) {
+	case BackendLite, BackendNative:
+		*s = Backend(raw)
+	default:
+		return newYamlError(node, "Unknown backend %s. Expects one of %s or %s", raw, BackendLite, BackendNative)
+	}
+
+	return nil
+}
+
+func (s *PushPolicy) UnmarshalYAML(node *yaml.Node) error {
+	var raw string
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+
+	switch PushPolicy(raw) {
+	case PushPolicyForce, PushPolicyFastForwardOnly, PushPolicyForceWithLease:
+		*s = PushPolicy(raw)
+	default:
+		return newYamlError(node, "Unknown push policy %s. Expects one of %s, %s or %s", raw, PushPolicyForce, PushPolicyFastForwardOnly, PushPolicyForceWithLease)
+	}
+
+	return nil
+}
+
+func (s *PrefixCollection) UnmarshalYAML(node *yaml.Node) error {
 	var raw StringCollection
-	if err := unmarshal(&raw); err != nil {
+	if err := node.Decode(&raw); err != nil {
 		return err
 	}
 
 	if len(raw) > 1 {
-		seen := []string{}
 		for _, prefix := range raw {
 			parts := strings.Split(prefix, ":")
 			if len(parts) != 2 {
-				return fmt.Errorf("Using several prefixes requires to use the syntax `source:target`. Got %s", prefix)
+				return newYamlError(node, "Using several prefixes requires to use the syntax `source:target`. Got %s", prefix)
 			}
-			if utils.InArray(seen, parts[1]) {
-				return fmt.Errorf("Cannot have two prefix splits under the same directory. Got twice %s", parts[1])
-			}
-			seen = append(seen, parts[1])
 		}
 	}
 
@@ -50,9 +288,9 @@ func (s *PrefixCollection) UnmarshalYAML(unmarshal func(interface{}) error) erro
 	return nil
 }
 
-func (s *GitUrl) UnmarshalYAML(unmarshal func(interface{}) error) error {
+func (s *GitUrl) UnmarshalYAML(node *yaml.Node) error {
 	var raw string
-	if err := unmarshal(&raw); err != nil {
+	if err := node.Decode(&raw); err != nil {
 		return err
 	}
 
@@ -61,36 +299,58 @@ func (s *GitUrl) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
-func (s *StringCollection) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	var rawString string
-	if err := unmarshal(&rawString); err == nil {
+func (s *StringCollection) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		var rawString string
+		if err := node.Decode(&rawString); err != nil {
+			return err
+		}
 		*s = []string{rawString}
-
-		return nil
+	case yaml.SequenceNode:
+		var rawArray []string
+		if err := node.Decode(&rawArray); err != nil {
+			return err
+		}
+		*s = rawArray
+	default:
+		return newYamlError(node, "expects a string or an array of strings")
 	}
 
-	var rawArray []string
-	if err := unmarshal(&rawArray); err == nil {
-		*s = rawArray
+	return nil
+}
 
-		return nil
-	}
+func (s *Split) UnmarshalYAML(node *yaml.Node) error {
+	type rawSplit Split
+	err := decodeNode(node, (*rawSplit)(s))
+	s.line = node.Line
 
-	return fmt.Errorf("expects a string or n array of strings")
+	return err
 }
 
-func (s *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+func (s *Config) UnmarshalYAML(node *yaml.Node) error {
 	var raw struct {
-		CacheDir   *GitUrl  `yaml:"cache_dir"`
-		CacheUrl   *GitUrl  `yaml:"cache_url"`
-		ProjectDir *GitUrl  `yaml:"project_dir"`
-		ProjectUrl *GitUrl  `yaml:"project_url"`
-		Splits     []Split  `yaml:"splits"`
-		Origins    []string `yaml:"origins"`
+		CacheDir       *GitUrl          `yaml:"cache_dir"`
+		CacheUrl       *GitUrl          `yaml:"cache_url"`
+		ProjectDir     *GitUrl          `yaml:"project_dir"`
+		ProjectUrl     *GitUrl          `yaml:"project_url"`
+		Splits         []Split          `yaml:"splits"`
+		Origins        []string         `yaml:"origins"`
+		ExcludeOrigins []string         `yaml:"exclude_origins"`
+		PushPolicy     PushPolicy       `yaml:"push_policy"`
+		Backend        Backend          `yaml:"backend"`
+		Include        StringCollection `yaml:"include"`
+		Discover       []Split          `yaml:"discover"`
+		DiscoverBranch string           `yaml:"discover_branch"`
 	}
 
-	if err := unmarshal(&raw); err != nil {
-		return err
+	decodeErr := decodeNode(node, &raw)
+	typeError, ok := decodeErr.(*yaml.TypeError)
+	if decodeErr != nil && !ok {
+		return decodeErr
+	}
+	if typeError == nil {
+		typeError = &yaml.TypeError{}
 	}
 
 	if raw.CacheDir != nil {
@@ -114,28 +374,355 @@ func (s *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if len(raw.Origins) == 0 {
 		raw.Origins = []string{".*"}
 	}
+	if raw.PushPolicy == "" {
+		raw.PushPolicy = PushPolicyForce
+	}
+	if raw.Backend == "" {
+		raw.Backend = BackendLite
+	}
 
 	*s = Config{
-		CacheUrl:   raw.CacheUrl,
-		ProjectUrl: raw.ProjectUrl,
-		Splits:     raw.Splits,
-		Origins:    raw.Origins,
+		CacheUrl:       raw.CacheUrl,
+		ProjectUrl:     raw.ProjectUrl,
+		Splits:         []Split{},
+		Origins:        raw.Origins,
+		ExcludeOrigins: raw.ExcludeOrigins,
+		PushPolicy:     raw.PushPolicy,
+		Backend:        raw.Backend,
+		Include:        raw.Include,
+		Discover:       raw.Discover,
+		DiscoverBranch: raw.DiscoverBranch,
+	}
+	for _, split := range raw.Splits {
+		if err := s.addSplit(split); err != nil {
+			typeError.Errors = append(typeError.Errors, fmt.Sprintf("line %d: %s", split.line, err))
+		}
+	}
+
+	if len(typeError.Errors) > 0 {
+		return typeError
 	}
 
 	return nil
 }
 
+// addSplit registers the split, once the global settings have been applied
+// to it and the variables of its targets have been replaced.
+func (s *Config) addSplit(split Split) error {
+	split, err := s.prepareSplit(split)
+	if err != nil {
+		return err
+	}
+
+	s.Splits = append(s.Splits, split)
+
+	return nil
+}
+
+// prepareSplit applies the global settings to the split and replaces the
+// variables of its targets.
+func (s *Config) prepareSplit(split Split) (Split, error) {
+	if err := split.expandVars(); err != nil {
+		return split, err
+	}
+	if split.PushPolicy == "" {
+		split.PushPolicy = s.PushPolicy
+	}
+	if split.Backend == "" {
+		split.Backend = s.Backend
+	}
+	if split.Backend != BackendNative && split.requiresNativeBackend() {
+		return split, fmt.Errorf("The split %s requires the backend %s", split.DisplayName(), BackendNative)
+	}
+	if split.Name != "" {
+		for _, existingSplit := range s.Splits {
+			if existingSplit.Name == split.Name {
+				return split, fmt.Errorf("Two splits are named %s", split.Name)
+			}
+		}
+	}
+	if len(split.Origins) == 0 {
+		split.Origins = s.Origins
+	}
+	split.ExcludeOrigins = append(split.ExcludeOrigins, s.ExcludeOrigins...)
+
+	return split, nil
+}
+
+var templateVarRegexp = regexp.MustCompile(`\$?\{(\w+)\}`)
+
+// expandVars replaces the variables "{prefix}", "{basename}", "{slug}" and
+// the custom vars of the split in targets, tag prefix and name. Environment
+// variables (ie. "${GH_TOKEN}") are left untouched.
+func (s *Split) expandVars() error {
+	vars := map[string]string{}
+	if len(s.Prefixes) > 0 {
+		from, _ := parsePrefix(s.Prefixes[0])
+		from = strings.Trim(from, "/")
+		vars["prefix"] = from
+		vars["basename"] = path.Base(from)
+		vars["slug"] = slug.Make(from)
+	}
+	for name, value := range s.Vars {
+		vars[name] = value
+	}
+
+	var err error
+	expand := func(text string) string {
+		return templateVarRegexp.ReplaceAllStringFunc(text, func(match string) string {
+			if strings.HasPrefix(match, "$") {
+				return match
+			}
+			name := strings.Trim(match, "{}")
+			value, ok := vars[name]
+			if !ok {
+				err = fmt.Errorf("Unknown variable %s in %s", match, text)
+				return match
+			}
+
+			return value
+		})
+	}
+
+	targets := StringCollection{}
+	for _, target := range s.Targets {
+		targets = append(targets, expand(target))
+	}
+	s.Targets = targets
+	s.TagPrefix = expand(s.TagPrefix)
+	s.Name = expand(s.Name)
+
+	return errors.Wrapf(err, "split %s", s.DisplayName())
+}
+
+// mergeSplits adds the splits defined in another file. Splits sharing a
+// target or a prefix with an existing split are reported as conflicts.
+func (s *Config) mergeSplits(filePath string, splits []Split) utils.Errors {
+	errs := utils.Errors{}
+	for _, split := range splits {
+		if err := s.mergeSplit(split); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %s", filePath, split.line, err))
+		}
+	}
+
+	return errs
+}
+
+func (s *Config) mergeSplit(split Split) error {
+	// Targets are compared once their variables are replaced
+	split, err := s.prepareSplit(split)
+	if err != nil {
+		return err
+	}
+
+	for _, existingSplit := range s.Splits {
+		for _, target := range split.Targets {
+			if utils.InArray(existingSplit.Targets, target) {
+				return fmt.Errorf("The target %s of the split %s is already used by the split %s", target, split.DisplayName(), existingSplit.DisplayName())
+			}
+		}
+		for _, prefix := range split.Prefixes {
+			from, _ := parsePrefix(prefix)
+			for _, existingPrefix := range existingSplit.Prefixes {
+				existingFrom, _ := parsePrefix(existingPrefix)
+				if strings.Trim(from, "/") == strings.Trim(existingFrom, "/") {
+					return fmt.Errorf("The prefix %s of the split %s is already split by the split %s", from, split.DisplayName(), existingSplit.DisplayName())
+				}
+			}
+		}
+	}
+
+	s.Splits = append(s.Splits, split)
+
+	return nil
+}
+
 func NewConfigFromFile(filePath string) (*Config, error) {
+	config, mistakes, err := loadConfigFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(mistakes) > 0 {
+		return nil, errors.Wrap(mistakes, "failed to load config file")
+	}
+
+	return config, nil
+}
+
+// ValidateConfigFile loads the config file like NewConfigFromFile, but returns
+// every mistake of the files along with the configuration loaded despite them.
+func ValidateConfigFile(filePath string) (*Config, utils.Errors) {
+	config, mistakes, err := loadConfigFile(filePath)
+	if err != nil {
+		return nil, append(mistakes, err)
+	}
+
+	return config, mistakes
+}
+
+// loadConfigFile loads the config file and the files it includes. Mistakes in
+// the values of the files are collected, and loading goes on without them.
+func loadConfigFile(filePath string) (*Config, utils.Errors, error) {
 	config := &Config{}
 
+	mistakes, err := unmarshalFile(filePath, &config)
+	if err != nil {
+		return nil, mistakes, err
+	}
+
+	baseDir := filepath.Dir(utils.ResolvePath(filePath))
+	for _, include := range config.Include {
+		pattern := utils.ResolvePath(include)
+		if !filepath.IsAbs(include) {
+			pattern = filepath.Join(baseDir, include)
+		}
+
+		includedPaths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, mistakes, errors.Wrapf(err, "invalid include %s", include)
+		}
+
+		for _, includedPath := range includedPaths {
+			// Included files only define splits, inheriting the global settings
+			var included struct {
+				Splits []Split `yaml:"splits"`
+			}
+			includedMistakes, err := unmarshalFile(includedPath, &included)
+			mistakes = append(mistakes, includedMistakes...)
+			if err != nil {
+				return nil, mistakes, err
+			}
+			mistakes = append(mistakes, config.mergeSplits(includedPath, included.Splits)...)
+		}
+	}
+
+	if err := config.discoverSplits(); err != nil {
+		return nil, mistakes, errors.Wrap(err, "failed to discover splits")
+	}
+
+	return config, mistakes, nil
+}
+
+// unmarshalFile strictly decodes the file. Mistakes in values are returned as
+// "file:line" errors while the rest of the file is decoded anyway.
+func unmarshalFile(filePath string, out interface{}) (utils.Errors, error) {
 	yamlFile, err := ioutil.ReadFile(utils.ResolvePath(filePath))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read config file")
 	}
 
-	if err = yaml.Unmarshal(yamlFile, &config); err != nil {
-		return nil, errors.Wrap(err, "failed to load config file")
+	decoder := yaml.NewDecoder(bytes.NewReader(yamlFile))
+	decoder.KnownFields(true)
+	err = decoder.Decode(out)
+	if err == nil || err == io.EOF {
+		return nil, nil
+	}
+	if _, ok := err.(*yaml.TypeError); ok {
+		return formatYamlError(filePath, err), nil
 	}
 
-	return config, nil
+	return nil, errors.Wrap(formatYamlError(filePath, err), "failed to load config file")
+}
+
+var yamlLineRegexp = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+var yamlUnknownFieldRegexp = regexp.MustCompile(`^field (\S+) not found in type .*$`)
+
+// formatYamlError prefixes the errors reported by the YAML decoder with the
+// "file:line" of the mistake, sorted by line.
+func formatYamlError(filePath string, err error) utils.Errors {
+	messages := []string{err.Error()}
+	if typeError, ok := err.(*yaml.TypeError); ok {
+		messages = typeError.Errors
+	}
+
+	type lineError struct {
+		line int
+		err  error
+	}
+	lineErrors := []lineError{}
+	for _, message := range messages {
+		matches := yamlLineRegexp.FindStringSubmatch(message)
+		if matches == nil {
+			lineErrors = append(lineErrors, lineError{0, fmt.Errorf("%s: %s", filePath, message)})
+			continue
+		}
+
+		line, _ := strconv.Atoi(matches[1])
+		message = yamlUnknownFieldRegexp.ReplaceAllString(matches[2], `unknown key "$1"`)
+		lineErrors = append(lineErrors, lineError{line, fmt.Errorf("%s:%d: %s", filePath, line, message)})
+	}
+	sort.SliceStable(lineErrors, func(i, j int) bool {
+		return lineErrors[i].line < lineErrors[j].line
+	})
+
+	errs := utils.Errors{}
+	for _, lineError := range lineErrors {
+		errs = append(errs, lineError.err)
+	}
+
+	return errs
+}
+
+// newYamlError reports a mistake at the line of the node. The mistake is
+// collected with the other mistakes of the file instead of aborting decoding.
+func newYamlError(node *yaml.Node, format string, args ...interface{}) error {
+	return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: ", node.Line) + fmt.Sprintf(format, args...)}}
+}
+
+// decodeNode decodes a mapping node and reports its unknown keys: contrary to
+// the decoder, decoding a node from a custom unmarshaler is never strict.
+func decodeNode(node *yaml.Node, out interface{}) error {
+	messages := []string{}
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.MappingNode {
+		knownKeys := map[string]bool{}
+		outType := reflect.TypeOf(out).Elem()
+		for i := 0; i < outType.NumField(); i++ {
+			knownKeys[strings.Split(outType.Field(i).Tag.Get("yaml"), ",")[0]] = true
+		}
+		for _, key := range mappingKeys(node) {
+			if !knownKeys[key.Value] {
+				messages = append(messages, fmt.Sprintf("line %d: field %s not found in type %s", key.Line, key.Value, outType))
+			}
+		}
+	}
+
+	if err := node.Decode(out); err != nil {
+		typeError, ok := err.(*yaml.TypeError)
+		if !ok {
+			return err
+		}
+		messages = append(messages, typeError.Errors...)
+	}
+	if len(messages) > 0 {
+		return &yaml.TypeError{Errors: messages}
+	}
+
+	return nil
+}
+
+// mappingKeys returns the keys of a mapping node, including the keys of the
+// mappings merged with "<<".
+func mappingKeys(node *yaml.Node) []*yaml.Node {
+	keys := []*yaml.Node{}
+	switch node.Kind {
+	case yaml.AliasNode:
+		keys = append(keys, mappingKeys(node.Alias)...)
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			keys = append(keys, mappingKeys(item)...)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Tag == "!!merge" {
+				keys = append(keys, mappingKeys(node.Content[i+1])...)
+			} else {
+				keys = append(keys, node.Content[i])
+			}
+		}
+	}
+
+	return keys
 }
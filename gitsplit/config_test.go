@@ -0,0 +1,295 @@
+package gitsplit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, dir string, name string, content string) string {
+	filePath := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return filePath
+}
+
+func TestNewConfigFromFilePushPolicy(t *testing.T) {
+	filePath := writeConfigFile(t, t.TempDir(), ".gitsplit.yml", `
+splits:
+  - prefix: src/partA
+    target: git@example.com:partA.git
+  - prefix: src/partB
+    target: git@example.com:partB.git
+    push_policy: force-with-lease
+push_policy: fast-forward-only
+`)
+
+	config, err := NewConfigFromFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Splits) != 2 {
+		t.Fatalf("expected 2 splits, got %d", len(config.Splits))
+	}
+	if config.Splits[0].PushPolicy != PushPolicyFastForwardOnly {
+		t.Errorf("expected the global push policy %s, got %s", PushPolicyFastForwardOnly, config.Splits[0].PushPolicy)
+	}
+	if config.Splits[1].PushPolicy != PushPolicyForceWithLease {
+		t.Errorf("expected the split push policy %s, got %s", PushPolicyForceWithLease, config.Splits[1].PushPolicy)
+	}
+
+	filePath = writeConfigFile(t, t.TempDir(), ".gitsplit.yml", `
+splits:
+  - prefix: src/partA
+    target: git@example.com:partA.git
+`)
+	if config, err = NewConfigFromFile(filePath); err != nil {
+		t.Fatal(err)
+	}
+	if config.Splits[0].PushPolicy != PushPolicyForce {
+		t.Errorf("expected the default push policy %s, got %s", PushPolicyForce, config.Splits[0].PushPolicy)
+	}
+}
+
+func TestNewConfigFromFileMergeKeys(t *testing.T) {
+	filePath := writeConfigFile(t, t.TempDir(), ".gitsplit.yml", `
+splits:
+  - &partA
+    prefix: src/partA
+    target: git@example.com:partA.git
+    push_policy: fast-forward-only
+  - <<: *partA
+    prefix: src/partB
+    target: git@example.com:partB.git
+`)
+
+	config, err := NewConfigFromFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Splits) != 2 {
+		t.Fatalf("expected 2 splits, got %d", len(config.Splits))
+	}
+	if config.Splits[1].PushPolicy != PushPolicyFastForwardOnly {
+		t.Errorf("expected the merged push policy %s, got %s", PushPolicyFastForwardOnly, config.Splits[1].PushPolicy)
+	}
+
+	// Keys of the merged mapping are checked too
+	filePath = writeConfigFile(t, t.TempDir(), ".gitsplit.yml", `
+splits:
+  - prefix: src/partB
+    target: git@example.com:partB.git
+    <<: {push_polcy: force}
+`)
+	if _, err := NewConfigFromFile(filePath); err == nil || !strings.Contains(err.Error(), filePath+`:5: unknown key "push_polcy"`) {
+		t.Errorf("expected the unknown merged key to be reported, got %v", err)
+	}
+}
+
+func TestNewConfigFromFileReportsLines(t *testing.T) {
+	cases := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name: "invalid push policy",
+			content: `splits:
+  - prefix: src/partA
+    target: git@example.com:partA.git
+    push_policy: sometimes
+`,
+			expected: []string{":4: Unknown push policy sometimes"},
+		},
+		{
+			name: "unknown keys",
+			content: `splits:
+  - prefix: src/partA
+    targets: git@example.com:partA.git
+orgins:
+  - ^main$
+`,
+			expected: []string{`:3: unknown key "targets"`, `:4: unknown key "orgins"`},
+		},
+		{
+			name: "invalid values of several types",
+			content: `backend: turbo
+splits:
+  - prefix: [src/partA, src/partB]
+    target: git@example.com:partA.git
+    rename:
+      - from: ^main$
+    message_filters:
+      - append: foo
+        pattern: bar
+`,
+			expected: []string{":1: Unknown backend turbo", ":3: Using several prefixes", ":6: A rename rule requires", ":8: A message filter requires"},
+		},
+		{
+			name: "split requiring the native backend",
+			content: `splits:
+  - prefix: src/partA
+    target: git@example.com:partA.git
+  - prefix: src/partB
+    target: git@example.com:partB.git
+    origin_trailer: true
+`,
+			expected: []string{":4: The split src/partB requires the backend native"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filePath := writeConfigFile(t, t.TempDir(), ".gitsplit.yml", c.content)
+
+			_, err := NewConfigFromFile(filePath)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, expected := range c.expected {
+				if !strings.Contains(err.Error(), filePath+expected) {
+					t.Errorf("expected %q in error, got %q", filePath+expected, err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewConfigFromFileIncludes(t *testing.T) {
+	dir := t.TempDir()
+	filePath := writeConfigFile(t, dir, ".gitsplit.yml", `
+include:
+  - "*.split.yml"
+splits:
+  - prefix: src/partA
+    target: git@example.com:partA.git
+push_policy: fast-forward-only
+`)
+	writeConfigFile(t, dir, "b.split.yml", `
+splits:
+  - prefix: src/partB
+    target: git@example.com:partB.git
+`)
+
+	config, err := NewConfigFromFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Splits) != 2 {
+		t.Fatalf("expected 2 splits, got %d", len(config.Splits))
+	}
+	if config.Splits[1].PushPolicy != PushPolicyFastForwardOnly {
+		t.Errorf("expected the included split to inherit the push policy, got %s", config.Splits[1].PushPolicy)
+	}
+
+	conflictPath := writeConfigFile(t, dir, "c.split.yml", `
+splits:
+  - prefix: src/partC
+    target: git@example.com:partA.git
+`)
+	if _, err := NewConfigFromFile(filePath); err == nil || !strings.Contains(err.Error(), conflictPath+":3: The target") {
+		t.Errorf("expected a conflict reported in %s, got %v", conflictPath, err)
+	}
+}
+
+func TestNewConfigFromFileIncludesTemplatedTargets(t *testing.T) {
+	dir := t.TempDir()
+	filePath := writeConfigFile(t, dir, ".gitsplit.yml", `
+include:
+  - "*.split.yml"
+`)
+	writeConfigFile(t, dir, "a.split.yml", `
+splits:
+  - prefix: src/component
+    target: git@example.com:{basename}.git
+`)
+	conflictPath := writeConfigFile(t, dir, "b.split.yml", `
+splits:
+  - prefix: lib/component
+    target: git@example.com:{basename}.git
+`)
+
+	_, err := NewConfigFromFile(filePath)
+	if err == nil || !strings.Contains(err.Error(), conflictPath+":3: The target git@example.com:component.git") {
+		t.Errorf("expected a conflict reported in %s, got %v", conflictPath, err)
+	}
+}
+
+func TestSplitMatchAlias(t *testing.T) {
+	split := Split{
+		Origins:        StringCollection{"^main$", "^feature/", "!^feature/wip-"},
+		ExcludeOrigins: StringCollection{"^feature/dependabot"},
+	}
+	cases := map[string]bool{
+		"main":                  true,
+		"main-old":              false,
+		"feature/foo":           true,
+		"feature/wip-foo":       false,
+		"feature/dependabot-up": false,
+	}
+	for alias, expected := range cases {
+		if got := split.MatchAlias(alias); got != expected {
+			t.Errorf("expected %s to match: %t, got %t", alias, expected, got)
+		}
+	}
+
+	// Origins made only of exclusions match everything else
+	split = Split{Origins: StringCollection{"!^dependabot/"}}
+	if !split.MatchAlias("main") || split.MatchAlias("dependabot/foo") {
+		t.Errorf("expected exclusions to only reject the references they match")
+	}
+}
+
+func TestSplitTargetReferenceRename(t *testing.T) {
+	split := Split{Rename: []RenameRule{
+		{From: "^main$", To: "2.x"},
+		{From: `^v(\d+\.\d+\.\d+)$`, To: "component-v$1"},
+		{From: "^.*$", To: "ignored"},
+	}}
+	cases := []struct {
+		reference Reference
+		expected  string
+	}{
+		{Reference{Alias: "main", ShortName: "heads/main", Name: "refs/heads/main"}, "refs/heads/2.x"},
+		{Reference{Alias: "v3.1.0", ShortName: "tags/v3.1.0", Name: "refs/tags/v3.1.0"}, "refs/tags/component-v3.1.0"},
+	}
+	for _, c := range cases {
+		target := split.TargetReference(c.reference)
+		if target.Name != c.expected || "refs/"+target.ShortName != c.expected {
+			t.Errorf("expected %s to be pushed as %s, got %s (%s)", c.reference.Name, c.expected, target.Name, target.ShortName)
+		}
+	}
+
+	reference := Reference{Alias: "main", ShortName: "heads/main", Name: "refs/heads/main"}
+	if target := (&Split{}).TargetReference(reference); target != reference {
+		t.Errorf("expected the reference to be kept without rename rules, got %v", target)
+	}
+}
+
+func TestSplitTagPrefix(t *testing.T) {
+	split := Split{
+		Origins:   StringCollection{"^main$", `^v\d+`},
+		TagPrefix: "partA/",
+	}
+	tag := Reference{Alias: "partA/v1.2.0", ShortName: "tags/partA/v1.2.0", Name: "refs/tags/partA/v1.2.0"}
+	if !split.MatchReference(tag) {
+		t.Errorf("expected the tag %s to be split", tag.Name)
+	}
+	if target := split.TargetReference(tag); target.Name != "refs/tags/v1.2.0" || target.ShortName != "tags/v1.2.0" {
+		t.Errorf("expected the tag to be pushed as refs/tags/v1.2.0, got %s (%s)", target.Name, target.ShortName)
+	}
+
+	other := Reference{Alias: "partB/v0.9.1", ShortName: "tags/partB/v0.9.1", Name: "refs/tags/partB/v0.9.1"}
+	if split.MatchReference(other) {
+		t.Errorf("expected the tag %s of another split to be ignored", other.Name)
+	}
+
+	// Branches are not affected by the tag prefix
+	branch := Reference{Alias: "main", ShortName: "heads/main", Name: "refs/heads/main"}
+	if !split.MatchReference(branch) || split.TargetReference(branch) != branch {
+		t.Errorf("expected the branch %s to be split and pushed unchanged", branch.Name)
+	}
+}
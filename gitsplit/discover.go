@@ -0,0 +1,173 @@
+package gitsplit
+
+import (
+	"fmt"
+	"github.com/jderusse/gitsplit/utils"
+	"github.com/libgit2/git2go"
+	"github.com/pkg/errors"
+	"path"
+	"strings"
+)
+
+// discoverSplits expands the discover templates into splits, one per
+// directory of the project matching the prefix glob of the template.
+// Directories already split by another split are ignored.
+func (c *Config) discoverSplits() error {
+	if len(c.Discover) == 0 {
+		return nil
+	}
+	if !c.ProjectUrl.IsLocal() {
+		return fmt.Errorf("Discovering splits requires a local project_url")
+	}
+
+	repository, err := git.OpenRepository(c.ProjectUrl.SchemelessUrl())
+	if err != nil {
+		return errors.Wrap(err, "failed to open project repository")
+	}
+	defer repository.Free()
+
+	branch, err := c.getDiscoverBranch(repository)
+	if err != nil {
+		return err
+	}
+	defer branch.Free()
+
+	tree, err := getTree(repository, branch.Target())
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the branch %s of the project repository", branch.Name())
+	}
+	defer tree.Free()
+
+	for _, template := range c.Discover {
+		if len(template.Prefixes) != 1 {
+			return fmt.Errorf("A discover entry requires exactly one prefix. Got %s", strings.Join(template.Prefixes, ", "))
+		}
+
+		directories, err := findDirectories(repository, tree, strings.Trim(template.Prefixes[0], "/"))
+		if err != nil {
+			return errors.Wrapf(err, "failed to discover %s", template.Prefixes[0])
+		}
+
+		for _, directory := range directories {
+			if c.isSplit(directory) {
+				continue
+			}
+
+			if err := c.addSplit(expandSplitTemplate(template, directory)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isSplit tells whether a split already splits the given directory.
+func (c *Config) isSplit(directory string) bool {
+	for _, split := range c.Splits {
+		for _, prefix := range split.Prefixes {
+			from, _ := parsePrefix(prefix)
+			if strings.Trim(from, "/") == directory {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func expandSplitTemplate(template Split, directory string) Split {
+	split := template
+	split.Prefixes = PrefixCollection{directory}
+	split.Vars = map[string]string{"name": path.Base(directory)}
+	for name, value := range template.Vars {
+		split.Vars[name] = value
+	}
+
+	return split
+}
+
+// getDiscoverBranch returns the branch in which splits are discovered: the
+// configured discover_branch, or the default branch of the origin of the
+// project. The checked out HEAD is never used, as it depends on the checkout.
+func (c *Config) getDiscoverBranch(repository *git.Repository) (*git.Reference, error) {
+	if c.DiscoverBranch != "" {
+		for _, name := range []string{"refs/heads/" + c.DiscoverBranch, "refs/remotes/origin/" + c.DiscoverBranch} {
+			if reference, err := repository.References.Lookup(name); err == nil {
+				return reference, nil
+			}
+		}
+
+		return nil, fmt.Errorf("The discover_branch %s does not exist in the project repository", c.DiscoverBranch)
+	}
+
+	originHead, err := repository.References.Lookup("refs/remotes/origin/HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot find the default branch of the project repository (refs/remotes/origin/HEAD), use discover_branch to define it")
+	}
+	defer originHead.Free()
+
+	reference, err := originHead.Resolve()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve the default branch of the project repository")
+	}
+
+	return reference, nil
+}
+
+// findDirectories returns the directories of the tree matching the glob.
+func findDirectories(repository *git.Repository, tree *git.Tree, pattern string) ([]string, error) {
+	base := utils.GlobBase(pattern)
+	if !utils.IsGlob(pattern) {
+		base = pattern
+	}
+
+	baseTree := tree
+	if base != "" {
+		entry, err := tree.EntryByPath(base)
+		if err != nil || entry.Type != git.ObjectTree {
+			return []string{}, nil
+		}
+		if baseTree, err = repository.LookupTree(entry.Id); err != nil {
+			return nil, err
+		}
+		defer baseTree.Free()
+	}
+
+	if !utils.IsGlob(pattern) {
+		return []string{pattern}, nil
+	}
+
+	// Without "**", directories deeper than the pattern can not match
+	maxDepth := -1
+	if !strings.Contains(pattern, "**") {
+		maxDepth = strings.Count(pattern, "/") - strings.Count(base, "/")
+		if base == "" {
+			maxDepth++
+		}
+	}
+
+	patternRegexp := utils.CompileGlob(pattern)
+	directories := []string{}
+	err := baseTree.Walk(func(directory string, entry *git.TreeEntry) int {
+		if entry.Type != git.ObjectTree {
+			return 0
+		}
+
+		entryPath := path.Join(base, directory, entry.Name)
+		if patternRegexp.MatchString(entryPath) {
+			directories = append(directories, entryPath)
+			return 1
+		}
+		if maxDepth >= 0 && strings.Count(directory, "/")+1 >= maxDepth {
+			return 1
+		}
+
+		return 0
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to walk tree")
+	}
+
+	return directories, nil
+}
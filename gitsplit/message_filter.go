@@ -0,0 +1,145 @@
+package gitsplit
+
+import (
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"strings"
+)
+
+const originTrailer = "Monorepo-Commit"
+
+var trailerRegexp = regexp.MustCompile(`^[A-Za-z0-9-]+: `)
+
+// MessageFilter rewrites the message of split commits. Each filter either
+// replaces a pattern, appends a text, or drops trailers.
+type MessageFilter struct {
+	Pattern      string           `yaml:"pattern"`
+	Replacement  string           `yaml:"replacement"`
+	Append       string           `yaml:"append"`
+	DropTrailers StringCollection `yaml:"drop_trailers"`
+}
+
+func (f *MessageFilter) UnmarshalYAML(node *yaml.Node) error {
+	var raw struct {
+		Pattern      string           `yaml:"pattern"`
+		Replacement  string           `yaml:"replacement"`
+		Append       string           `yaml:"append"`
+		DropTrailers StringCollection `yaml:"drop_trailers"`
+	}
+	if err := decodeNode(node, &raw); err != nil {
+		return err
+	}
+
+	kinds := 0
+	if raw.Pattern != "" {
+		kinds++
+		if _, err := regexp.Compile(raw.Pattern); err != nil {
+			return newYamlError(node, "Invalid message filter %s: %s", raw.Pattern, err)
+		}
+	}
+	if raw.Append != "" {
+		kinds++
+	}
+	if len(raw.DropTrailers) > 0 {
+		kinds++
+	}
+	if kinds != 1 {
+		return newYamlError(node, "A message filter requires exactly one of `pattern`, `append` or `drop_trailers`")
+	}
+
+	*f = MessageFilter(raw)
+
+	return nil
+}
+
+// Apply returns the filtered message of the commit sourceId.
+func (f *MessageFilter) Apply(message string, sourceId string) string {
+	switch {
+	case f.Pattern != "":
+		return regexp.MustCompile(f.Pattern).ReplaceAllString(message, f.Replacement)
+	case f.Append != "":
+		return strings.TrimRight(message, "\n") + "\n\n" + expandCommitPlaceholders(f.Append, sourceId) + "\n"
+	case len(f.DropTrailers) > 0:
+		return dropTrailers(message, f.DropTrailers)
+	}
+
+	return message
+}
+
+func applyMessageFilters(filters []MessageFilter, message string, sourceId string) string {
+	for _, filter := range filters {
+		message = filter.Apply(message, sourceId)
+	}
+
+	return message
+}
+
+func expandCommitPlaceholders(text string, sourceId string) string {
+	shortId := sourceId
+	if len(shortId) > 7 {
+		shortId = shortId[:7]
+	}
+
+	return strings.NewReplacer("{sha}", sourceId, "{short_sha}", shortId).Replace(text)
+}
+
+// findTrailers returns the index of the first line of the trailers (ie. the
+// last paragraph when every of its lines is a "Key: value" line), or -1 when
+// the message has no trailers.
+func findTrailers(lines []string) int {
+	start := len(lines)
+	for start > 0 && strings.TrimSpace(lines[start-1]) != "" {
+		start--
+	}
+
+	// The subject is never a trailer
+	if start == 0 || start == len(lines) {
+		return -1
+	}
+	for _, line := range lines[start:] {
+		if !trailerRegexp.MatchString(line) {
+			return -1
+		}
+	}
+
+	return start
+}
+
+// appendTrailer adds a "Key: value" line to the trailers of the message,
+// creating the trailers paragraph when needed.
+func appendTrailer(message string, key string, value string) string {
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+
+	separator := "\n\n"
+	if findTrailers(lines) >= 0 {
+		separator = "\n"
+	}
+
+	return strings.Join(lines, "\n") + separator + key + ": " + value + "\n"
+}
+
+// dropTrailers removes the given trailers (ie. "Key: value" lines) from the
+// trailers paragraph of the message.
+func dropTrailers(message string, keys []string) string {
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+	start := findTrailers(lines)
+	if start < 0 {
+		return message
+	}
+
+	kept := append([]string{}, lines[:start]...)
+	for _, line := range lines[start:] {
+		dropped := false
+		for _, key := range keys {
+			if strings.HasPrefix(strings.ToLower(line), strings.ToLower(key)+":") {
+				dropped = true
+				break
+			}
+		}
+		if !dropped {
+			kept = append(kept, line)
+		}
+	}
+
+	return strings.TrimRight(strings.Join(kept, "\n"), "\n") + "\n"
+}
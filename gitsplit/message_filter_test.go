@@ -0,0 +1,51 @@
+package gitsplit
+
+import (
+	"testing"
+)
+
+func TestAppendTrailer(t *testing.T) {
+	cases := []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{"subject only", "Fix bug\n", "Fix bug\n\nMonorepo-Commit: abc\n"},
+		{"subject without newline", "Fix bug", "Fix bug\n\nMonorepo-Commit: abc\n"},
+		{"body", "Fix bug\n\nSome details.\n", "Fix bug\n\nSome details.\n\nMonorepo-Commit: abc\n"},
+		{"existing trailers", "Fix bug\n\nSigned-off-by: Jane <jane@example.com>\n", "Fix bug\n\nSigned-off-by: Jane <jane@example.com>\nMonorepo-Commit: abc\n"},
+		{"subject looking like a trailer", "Note: fix bug\n", "Note: fix bug\n\nMonorepo-Commit: abc\n"},
+		{"prose with a colon", "Fix bug\n\nWarning: this is prose\nspanning two lines.\n", "Fix bug\n\nWarning: this is prose\nspanning two lines.\n\nMonorepo-Commit: abc\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := appendTrailer(c.message, "Monorepo-Commit", "abc"); actual != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDropTrailers(t *testing.T) {
+	cases := []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{"subject only", "Change-Id: I123\n", "Change-Id: I123\n"},
+		{"no trailers", "Fix bug\n\nSome details.\n", "Fix bug\n\nSome details.\n"},
+		{"dropped trailer", "Fix bug\n\nChange-Id: I123\nSigned-off-by: Jane <jane@example.com>\n", "Fix bug\n\nSigned-off-by: Jane <jane@example.com>\n"},
+		{"case insensitive", "Fix bug\n\nchange-id: I123\nSigned-off-by: Jane <jane@example.com>\n", "Fix bug\n\nSigned-off-by: Jane <jane@example.com>\n"},
+		{"every trailer dropped", "Fix bug\n\nBody.\n\nChange-Id: I123\nReviewed-on: https://review.example.com/1\n", "Fix bug\n\nBody.\n"},
+		{"prose is kept", "Fix bug\n\nChange-Id: I123 is mentioned\nin this prose paragraph.\n", "Fix bug\n\nChange-Id: I123 is mentioned\nin this prose paragraph.\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := dropTrailers(c.message, []string{"Change-Id", "Reviewed-on"}); actual != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package gitsplit
+
+import (
+	"github.com/jderusse/gitsplit/utils"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pathFilter matches paths of a tree with a glob, the same way .gitignore
+// does: a pattern without "/" matches the name of the entry at any depth, a
+// leading "/" anchors the pattern to the root of the tree, and a trailing "/"
+// only matches directories.
+type pathFilter struct {
+	regexp    *regexp.Regexp
+	basename  bool
+	directory bool
+}
+
+func newPathFilter(pattern string) *pathFilter {
+	anchored := strings.HasPrefix(pattern, "/")
+	directory := strings.HasSuffix(pattern, "/")
+	pattern = strings.Trim(pattern, "/")
+
+	return &pathFilter{
+		regexp:    utils.CompileGlob(pattern),
+		basename:  !anchored && !strings.Contains(pattern, "/"),
+		directory: directory,
+	}
+}
+
+func newPathFilters(patterns []string) []*pathFilter {
+	filters := []*pathFilter{}
+	for _, pattern := range patterns {
+		filters = append(filters, newPathFilter(pattern))
+	}
+
+	return filters
+}
+
+func (f *pathFilter) Match(entryPath string, isTree bool) bool {
+	if f.directory && !isTree {
+		return false
+	}
+	if f.basename {
+		entryPath = path.Base(entryPath)
+	}
+
+	return f.regexp.MatchString(entryPath)
+}
+
+func matchPathFilters(filters []*pathFilter, entryPath string, isTree bool) bool {
+	for _, filter := range filters {
+		if filter.Match(entryPath, isTree) {
+			return true
+		}
+	}
+
+	return false
+}
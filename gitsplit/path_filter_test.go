@@ -0,0 +1,31 @@
+package gitsplit
+
+import (
+	"testing"
+)
+
+func TestPathFilterMatch(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		path     string
+		isTree   bool
+		expected bool
+	}{
+		{"*.log", "debug.log", false, true},
+		{"*.log", "var/logs/debug.log", false, true},
+		{"/*.log", "debug.log", false, true},
+		{"/*.log", "var/debug.log", false, false},
+		{"tests/", "tests", true, true},
+		{"tests/", "src/tests", true, true},
+		{"tests/", "tests", false, false},
+		{"docs/*.md", "docs/index.md", false, true},
+		{"docs/*.md", "src/docs/index.md", false, false},
+		{"**/fixtures", "tests/unit/fixtures", true, true},
+	}
+
+	for _, c := range cases {
+		if got := newPathFilter(c.pattern).Match(c.path, c.isTree); got != c.expected {
+			t.Errorf("expected %s to match %s: %t, got %t", c.pattern, c.path, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package gitsplit
+
+import (
+	"github.com/libgit2/git2go"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+type ReferenceSplitter interface {
+	// Split rewrites the history of the reference to only keep the prefixes of
+	// the split, and returns the head of the rewritten history. The previous
+	// cache item, when known, can be used to resume an older split.
+	Split(reference string, split Split, previous *CacheItem) (*git.Oid, error)
+}
+
+func NewReferenceSplitter(backend Backend, repository *git.Repository, commitMap *CommitMap, splitMap *SplitMap) ReferenceSplitter {
+	switch backend {
+	case BackendNative:
+		return NewReferenceSplitterNative(repository, commitMap, splitMap)
+	default:
+		return NewReferenceSplitterLite(repository)
+	}
+}
+
+// peelCommitId returns the id of the commit pointed by the object, following
+// annotated tags.
+func peelCommitId(repository *git.Repository, id *git.Oid) (*git.Oid, error) {
+	object, err := repository.Lookup(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find object %s", id)
+	}
+	defer object.Free()
+
+	commit, err := object.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find the commit of %s", id)
+	}
+	defer commit.Free()
+
+	return commit.Id(), nil
+}
+
+func parsePrefix(prefix string) (string, string) {
+	parts := strings.Split(prefix, ":")
+	from := parts[0]
+	to := ""
+	if len(parts) > 1 {
+		to = parts[1]
+	}
+
+	return from, to
+}
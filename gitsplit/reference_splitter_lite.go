@@ -1,41 +1,137 @@
 package gitsplit
 
 import (
+	"fmt"
+	"github.com/jderusse/gitsplit/utils"
 	"github.com/libgit2/git2go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 	lite "github.com/splitsh/lite/splitter"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 )
 
 func NewReferenceSplitterLite(repository *git.Repository) *ReferenceSplitterLite {
 	return &ReferenceSplitterLite{
-		repository: repository,
+		repository:         repository,
+		workingCopies:      make(map[string]*liteWorkingCopy),
+		mutexWorkingCopies: &sync.Mutex{},
+		resumer:            NewReferenceSplitterNative(repository, nil, nil),
+		checkedSplits:      make(map[string]bool),
+		mutexChecks:        &sync.Mutex{},
 	}
 }
 
 type ReferenceSplitterLite struct {
-	repository *git.Repository
+	repository         *git.Repository
+	workingCopies      map[string]*liteWorkingCopy
+	mutexWorkingCopies *sync.Mutex
+	resumer            *ReferenceSplitterNative
+	checkedSplits      map[string]bool
+	mutexChecks        *sync.Mutex
+}
+
+// liteWorkingCopy is the repository in which splitsh/lite splits the
+// references of a split. splitsh/lite holds an exclusive lock on its
+// splitsh.db while splitting: each split has its own working copy and
+// splitsh.db, sharing the objects and references of the working space, so
+// that splits run concurrently. References of the same split still wait for
+// each other.
+type liteWorkingCopy struct {
+	path  string
+	mutex *sync.Mutex
+}
+
+func getLiteWorkingCopyPath(repositoryPath string, split Split) string {
+	return filepath.Join(repositoryPath, "lite", utils.Hash(split.cacheKey()))
+}
+
+func (r *ReferenceSplitterLite) getWorkingCopy(split Split) (*liteWorkingCopy, error) {
+	r.mutexWorkingCopies.Lock()
+	defer r.mutexWorkingCopies.Unlock()
+
+	key := split.cacheKey()
+	if workingCopy, ok := r.workingCopies[key]; ok {
+		return workingCopy, nil
+	}
+
+	workingCopyPath := getLiteWorkingCopyPath(r.repository.Path(), split)
+	repository, err := git.InitRepository(workingCopyPath, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create working copy %s", workingCopyPath)
+	}
+	repository.Free()
+
+	for _, name := range []string{"objects", "refs"} {
+		linkPath := filepath.Join(workingCopyPath, name)
+		if err := os.RemoveAll(linkPath); err != nil {
+			return nil, errors.Wrapf(err, "failed to create working copy %s", workingCopyPath)
+		}
+		if err := os.Symlink(filepath.Join("..", "..", name), linkPath); err != nil {
+			return nil, errors.Wrapf(err, "failed to create working copy %s", workingCopyPath)
+		}
+	}
+
+	workingCopy := &liteWorkingCopy{
+		path:  workingCopyPath,
+		mutex: &sync.Mutex{},
+	}
+	r.workingCopies[key] = workingCopy
+
+	return workingCopy, nil
 }
 
 func formatLitePrefixes(prefixes []string) []*lite.Prefix {
 	litePrefixes := []*lite.Prefix{}
 	for _, prefix := range prefixes {
-		parts := strings.Split(prefix, ":")
-		from := parts[0]
-		to := ""
-		if len(parts) > 1 {
-			to = parts[1]
-		}
+		from, to := parsePrefix(prefix)
 		litePrefixes = append(litePrefixes, &lite.Prefix{From: from, To: to})
 	}
 
 	return litePrefixes
 }
 
-func (r *ReferenceSplitterLite) Split(reference string, prefixes []string) (*git.Oid, error) {
+// Split delegates the split to splitsh/lite which maintains its own cache in
+// the splitsh.db of the working copy of the split. When splitsh.db was lost, the previous cache item is used to
+// only rewrite the new commits, following the same rules as splitsh/lite.
+// The history split by splitsh/lite is never split again natively: when the
+// new commits can not be attached to the previous split, splitsh/lite splits
+// the whole history.
+func (r *ReferenceSplitterLite) Split(reference string, split Split, previous *CacheItem) (*git.Oid, error) {
+	workingCopy, err := r.getWorkingCopy(split)
+	if err != nil {
+		return nil, err
+	}
+	workingCopy.mutex.Lock()
+	defer workingCopy.mutex.Unlock()
+
+	hasDatabase := utils.FileExists(filepath.Join(workingCopy.path, "splitsh.db"))
+	if !hasDatabase && previous != nil && previous.SourceId() != nil && previous.TargetId() != nil {
+		log.WithFields(log.Fields{
+		    "reference": reference,
+		    "source": previous.SourceId().String(),
+		}).Info("No splitsh.db found, resuming the previous split")
+
+		splitId, err := r.resumer.Resume(reference, split, previous)
+		if err == nil {
+			return splitId, nil
+		}
+		log.WithFields(log.Fields{
+		    "reference": reference,
+		    "error": err,
+		}).Warn("Cannot resume the previous split, splitting the whole history")
+	}
+
+	if err := r.checkPrefixes(reference, split); err != nil {
+		return nil, err
+	}
+
 	config := &lite.Config{
-		Path:       r.repository.Path(),
+		Path:       workingCopy.path,
 		Origin:     reference,
-		Prefixes:   formatLitePrefixes(prefixes),
+		Prefixes:   formatLitePrefixes(split.Prefixes),
 		Target:     "",
 		Commit:     "",
 		Debug:      false,
@@ -50,3 +146,50 @@ func (r *ReferenceSplitterLite) Split(reference string, prefixes []string) (*git
 
 	return result.Head(), nil
 }
+
+// checkPrefixes ensures the prefixes of the split are directories, splitsh/lite
+// does not support splitting files. Each split is checked once, against the
+// first reference split.
+func (r *ReferenceSplitterLite) checkPrefixes(reference string, split Split) error {
+	r.mutexChecks.Lock()
+	defer r.mutexChecks.Unlock()
+
+	if r.checkedSplits[split.cacheKey()] {
+		return nil
+	}
+
+	gitReference, err := r.repository.References.Lookup(reference)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve reference %s", reference)
+	}
+	defer gitReference.Free()
+
+	// Annotated tags point to a tag object, not to a commit
+	commitId, err := peelCommitId(r.repository, gitReference.Target())
+	if err != nil {
+		return err
+	}
+
+	commit, err := r.repository.LookupCommit(commitId)
+	if err != nil {
+		return errors.Wrapf(err, "failed to find commit %s", commitId)
+	}
+	defer commit.Free()
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch commit tree")
+	}
+	defer tree.Free()
+
+	for _, prefix := range split.Prefixes {
+		from, _ := parsePrefix(prefix)
+		entry, err := tree.EntryByPath(strings.Trim(from, "/"))
+		if err == nil && entry.Type != git.ObjectTree {
+			return fmt.Errorf("The prefix %s is a file, splitting files requires the backend %s", from, BackendNative)
+		}
+	}
+	r.checkedSplits[split.cacheKey()] = true
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package gitsplit
+
+import (
+	"github.com/libgit2/git2go"
+	"testing"
+)
+
+func TestReferenceSplitterLiteWorkingCopy(t *testing.T) {
+	repository := newTestRepository(t)
+	splitter := NewReferenceSplitterLite(repository)
+	splitA := Split{Prefixes: PrefixCollection{"src/partA"}}
+	splitB := Split{Prefixes: PrefixCollection{"src/partB"}}
+
+	workingCopyA, err := splitter.getWorkingCopy(splitA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workingCopy, err := splitter.getWorkingCopy(splitA); err != nil || workingCopy != workingCopyA {
+		t.Errorf("expected the working copy to be reused, got %v", err)
+	}
+	workingCopyB, err := splitter.getWorkingCopy(splitB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workingCopyA.path == workingCopyB.path || workingCopyA.mutex == workingCopyB.mutex {
+		t.Errorf("expected each split to have its own working copy")
+	}
+
+	// Objects and references created in the working space are shared
+	commitId := commitFiles(t, repository, "root", map[string]string{"src/partA/file": "1"})
+	setTestReference(t, repository, "refs/split-temp/partA", commitId)
+
+	workingCopy, err := git.OpenRepository(workingCopyA.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer workingCopy.Free()
+
+	reference, err := workingCopy.References.Lookup("refs/split-temp/partA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reference.Free()
+	commit, err := workingCopy.LookupCommit(reference.Target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit.Free()
+
+	// Split commits created by splitsh/lite are visible in the working space
+	blobId, err := workingCopy.CreateBlobFromBuffer([]byte("split"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := repository.LookupBlob(blobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob.Free()
+}
@@ -0,0 +1,653 @@
+package gitsplit
+
+import (
+	"github.com/jderusse/gitsplit/utils"
+	"github.com/libgit2/git2go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"path"
+	"strings"
+	"sync"
+)
+
+func NewReferenceSplitterNative(repository *git.Repository, commitMap *CommitMap, splitMap *SplitMap) *ReferenceSplitterNative {
+	return &ReferenceSplitterNative{
+		repository:  repository,
+		commitMap:   commitMap,
+		splitMap:    splitMap,
+		states:      make(map[string]*nativeSplitState),
+		mutexStates: &sync.Mutex{},
+	}
+}
+
+// ReferenceSplitterNative splits references by walking commits with git2go.
+// It follows the same rules as git-subtree and splitsh/lite, and keeps the
+// mapping between source and split commits in the split map of the cache.
+type ReferenceSplitterNative struct {
+	repository  *git.Repository
+	commitMap   *CommitMap
+	splitMap    *SplitMap
+	states      map[string]*nativeSplitState
+	mutexStates *sync.Mutex
+}
+
+type nativeSplitState struct {
+	repository     *git.Repository
+	commitMap      *CommitMap
+	splitMap       *SplitMap
+	split          Split
+	excludeFilters []*pathFilter
+	commits        map[git.Oid]*git.Oid
+	newCommits     map[git.Oid]*git.Oid
+	trees          map[string]*git.Oid
+	mutex          *sync.Mutex
+}
+
+// errUnknownHistory is returned when resuming a split requires to split
+// commits older than the previous split.
+var errUnknownHistory = errors.New("the reference merges commits older than the previous split")
+
+func (r *ReferenceSplitterNative) getState(split Split) (*nativeSplitState, error) {
+	r.mutexStates.Lock()
+	defer r.mutexStates.Unlock()
+
+	key := split.cacheKey()
+	if state, ok := r.states[key]; ok {
+		return state, nil
+	}
+
+	commits := make(map[git.Oid]*git.Oid)
+	if r.splitMap != nil {
+		var err error
+		if commits, err = r.splitMap.Commits(split); err != nil {
+			return nil, err
+		}
+	}
+
+	state := &nativeSplitState{
+		repository:     r.repository,
+		commitMap:      r.commitMap,
+		splitMap:       r.splitMap,
+		split:          split,
+		excludeFilters: newPathFilters(split.Exclude),
+		commits:        commits,
+		newCommits:     make(map[git.Oid]*git.Oid),
+		trees:          make(map[string]*git.Oid),
+		mutex:          &sync.Mutex{},
+	}
+	r.states[key] = state
+
+	return state, nil
+}
+
+// getHead returns the commit pointed by the reference.
+func (r *ReferenceSplitterNative) getHead(reference string) (*git.Oid, error) {
+	gitReference, err := r.repository.References.Lookup(reference)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find reference %s", reference)
+	}
+	defer gitReference.Free()
+
+	// Annotated tags point to a tag object, not to a commit
+	return peelCommitId(r.repository, gitReference.Target())
+}
+
+// Split rewrites the commits of the reference which have never been split.
+// Commits split by previous runs are read from the split map, the previous
+// cache item is only used when the split map was lost.
+func (r *ReferenceSplitterNative) Split(reference string, split Split, previous *CacheItem) (*git.Oid, error) {
+	headId, err := r.getHead(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := r.getState(split)
+	if err != nil {
+		return nil, err
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if previous != nil && previous.SourceId() != nil {
+		sourceId, err := peelCommitId(r.repository, previous.SourceId())
+		if err == nil {
+			state.resume(sourceId, previous.TargetId())
+		}
+	}
+
+	splitId, err := state.splitCommit(headId, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitId, state.save()
+}
+
+// Resume only rewrites the commits of the reference which are not part of
+// the history of the previous split. It fails with errUnknownHistory when the
+// reference merges a branch forked before the previous split, as the split of
+// the fork point is unknown, and when the previous split was not produced
+// with the rules of this backend.
+func (r *ReferenceSplitterNative) Resume(reference string, split Split, previous *CacheItem) (*git.Oid, error) {
+	headId, err := r.getHead(reference)
+	if err != nil {
+		return nil, err
+	}
+	sourceId, err := peelCommitId(r.repository, previous.SourceId())
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := r.getState(split)
+	if err != nil {
+		return nil, err
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if !state.resume(sourceId, previous.TargetId()) {
+		return nil, errUnknownHistory
+	}
+
+	newCommits, err := state.listNewCommits(headId, sourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	splitId, err := state.splitCommit(headId, newCommits)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitId, state.save()
+}
+
+// resume registers a previously computed split, so that the walk stops at
+// the source commit instead of rewriting the whole history again. The split
+// is ignored when its tree is not the one this backend builds from the source
+// commit, ie. when it was produced with other rules.
+func (s *nativeSplitState) resume(sourceId *git.Oid, targetId *git.Oid) bool {
+	if sourceId == nil || targetId == nil {
+		return false
+	}
+	if splitId, ok := s.commits[*sourceId]; ok {
+		return splitId != nil && splitId.Equal(targetId)
+	}
+
+	contextualLog := log.WithFields(log.Fields{
+	    "source": sourceId.String(),
+	    "target": targetId.String(),
+	})
+
+	target, err := s.repository.LookupCommit(targetId)
+	if err != nil {
+		contextualLog.Warn("Cached split not found, ignoring it")
+		return false
+	}
+	targetTreeId := target.TreeId()
+	target.Free()
+
+	source, err := s.repository.LookupCommit(sourceId)
+	if err != nil {
+		contextualLog.Warn("Cached source not found, ignoring it")
+		return false
+	}
+	treeId, err := s.splitTree(source)
+	source.Free()
+	if err != nil || treeId == nil || !treeId.Equal(targetTreeId) {
+		contextualLog.Warn("Cached split does not match the split of its source, ignoring it")
+		return false
+	}
+
+	s.commits[*sourceId] = targetId
+
+	return true
+}
+
+// listNewCommits returns the commits of the head which are not part of the
+// history of the source commit.
+func (s *nativeSplitState) listNewCommits(headId *git.Oid, sourceId *git.Oid) (map[git.Oid]bool, error) {
+	walk, err := s.repository.Walk()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to walk history")
+	}
+	defer walk.Free()
+
+	if err := walk.Push(headId); err != nil {
+		return nil, errors.Wrapf(err, "failed to walk commit %s", headId)
+	}
+	if err := walk.Hide(sourceId); err != nil {
+		return nil, errors.Wrapf(err, "failed to walk commit %s", sourceId)
+	}
+
+	commits := map[git.Oid]bool{}
+	err = walk.Iterate(func(commit *git.Commit) bool {
+		commits[*commit.Id()] = true
+		commit.Free()
+
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to walk history")
+	}
+
+	return commits, nil
+}
+
+// splitCommit splits the commit and its ancestors which have never been
+// split. When allowed is given, only these commits can be split.
+func (s *nativeSplitState) splitCommit(head *git.Oid, allowed map[git.Oid]bool) (*git.Oid, error) {
+	stack := []*git.Oid{head}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		if _, ok := s.commits[*id]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if allowed != nil && !allowed[*id] {
+			return nil, errors.Wrapf(errUnknownHistory, "the split of commit %s is unknown", id)
+		}
+
+		commit, err := s.repository.LookupCommit(id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find commit %s", id)
+		}
+
+		// Parents have to be split first
+		pending := false
+		for i := uint(0); i < commit.ParentCount(); i++ {
+			parentId := commit.ParentId(i)
+			if _, ok := s.commits[*parentId]; !ok {
+				stack = append(stack, parentId)
+				pending = true
+			}
+		}
+		if pending {
+			commit.Free()
+			continue
+		}
+
+		stack = stack[:len(stack)-1]
+		splitId, err := s.rewriteCommit(commit)
+		commit.Free()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to split commit %s", id)
+		}
+		s.commits[*id] = splitId
+		if s.splitMap != nil {
+			s.newCommits[*id] = splitId
+		}
+	}
+
+	return s.commits[*head], nil
+}
+
+// save records the commits split since the last save in the split map.
+func (s *nativeSplitState) save() error {
+	if len(s.newCommits) == 0 {
+		return nil
+	}
+
+	if err := s.splitMap.Add(s.split, s.newCommits); err != nil {
+		return err
+	}
+	s.newCommits = make(map[git.Oid]*git.Oid)
+
+	return nil
+}
+
+func (s *nativeSplitState) rewriteCommit(commit *git.Commit) (*git.Oid, error) {
+	parents := []*git.Oid{}
+	for i := uint(0); i < commit.ParentCount(); i++ {
+		parentId := s.commits[*commit.ParentId(i)]
+		if parentId == nil {
+			continue
+		}
+		duplicated := false
+		for _, parent := range parents {
+			if parent.Equal(parentId) {
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			parents = append(parents, parentId)
+		}
+	}
+
+	treeId, err := s.splitTree(commit)
+	if err != nil {
+		return nil, err
+	}
+	if treeId == nil {
+		// The prefixes do not exist in this commit
+		if len(parents) > 0 {
+			return parents[0], nil
+		}
+
+		return nil, nil
+	}
+
+	if identical, err := s.findIdenticalParent(treeId, parents); err != nil {
+		return nil, err
+	} else if identical != nil {
+		return identical, nil
+	}
+
+	return s.copyCommit(commit, treeId, parents)
+}
+
+// findIdenticalParent returns the parent which can be used in place of the
+// commit, following the rules of git-subtree's copy_or_skip.
+func (s *nativeSplitState) findIdenticalParent(treeId *git.Oid, parents []*git.Oid) (*git.Oid, error) {
+	var identical *git.Oid
+	var nonIdentical *git.Oid
+	for _, parentId := range parents {
+		parent, err := s.repository.LookupCommit(parentId)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find commit %s", parentId)
+		}
+		parentTreeId := parent.TreeId()
+		parent.Free()
+
+		if !parentTreeId.Equal(treeId) {
+			nonIdentical = parentId
+			continue
+		}
+		if identical == nil {
+			identical = parentId
+			continue
+		}
+
+		// Several parents are identical: keep the most recent one, unless they
+		// do not share history
+		if isAncestor, err := s.repository.DescendantOf(parentId, identical); err != nil {
+			return nil, err
+		} else if isAncestor {
+			identical = parentId
+		} else if isDescendant, err := s.repository.DescendantOf(identical, parentId); err != nil {
+			return nil, err
+		} else if !isDescendant {
+			return nil, nil
+		}
+	}
+
+	if identical != nil && nonIdentical != nil {
+		// Preserve the history of the other branch
+		if isDescendant, err := s.repository.DescendantOf(identical, nonIdentical); err != nil {
+			return nil, err
+		} else if !isDescendant {
+			return nil, nil
+		}
+	}
+
+	return identical, nil
+}
+
+func (s *nativeSplitState) copyCommit(commit *git.Commit, treeId *git.Oid, parentIds []*git.Oid) (*git.Oid, error) {
+	tree, err := s.repository.LookupTree(treeId)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find tree %s", treeId)
+	}
+	defer tree.Free()
+
+	parents := []*git.Commit{}
+	for _, parentId := range parentIds {
+		parent, err := s.repository.LookupCommit(parentId)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find commit %s", parentId)
+		}
+		defer parent.Free()
+		parents = append(parents, parent)
+	}
+
+	message := applyMessageFilters(s.split.MessageFilters, commit.Message(), commit.Id().String())
+	if s.split.OriginTrailer {
+		message = appendTrailer(message, originTrailer, commit.Id().String())
+	}
+
+	splitId, err := s.repository.CreateCommit("", commit.Author(), commit.Committer(), message, tree, parents...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create commit")
+	}
+
+	if s.split.OriginTrailer && s.commitMap != nil {
+		if err := s.commitMap.Add(splitId, commit.Id()); err != nil {
+			return nil, err
+		}
+	}
+
+	return splitId, nil
+}
+
+// splitTree builds the tree of the split commit, or returns nil when none of
+// the prefixes (directories or files) exist in the commit.
+func (s *nativeSplitState) splitTree(commit *git.Commit) (*git.Oid, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch commit tree")
+	}
+	defer tree.Free()
+
+	entries := []*git.TreeEntry{}
+	keys := []string{}
+	for _, prefix := range s.split.Prefixes {
+		from, _ := parsePrefix(prefix)
+		entry, err := tree.EntryByPath(strings.Trim(from, "/"))
+		if err != nil || (entry.Type != git.ObjectTree && entry.Type != git.ObjectBlob) {
+			entries = append(entries, nil)
+			keys = append(keys, "")
+			continue
+		}
+		entries = append(entries, entry)
+		keys = append(keys, entry.Id.String())
+	}
+
+	includeEntries := []*git.TreeEntry{}
+	for _, include := range s.split.Include {
+		entry := s.getIncludeEntry(tree, include)
+		includeEntries = append(includeEntries, entry)
+		if entry == nil {
+			keys = append(keys, "")
+		} else {
+			keys = append(keys, entry.Id.String())
+		}
+	}
+
+	overlayEntry, err := tree.EntryByPath(strings.Trim(s.split.Overlay, "/"))
+	if s.split.Overlay == "" || err != nil || overlayEntry.Type != git.ObjectTree {
+		overlayEntry = nil
+		keys = append(keys, "")
+	} else {
+		keys = append(keys, overlayEntry.Id.String())
+	}
+
+	key := strings.Join(keys, "-")
+	if treeId, ok := s.trees[key]; ok {
+		return treeId, nil
+	}
+
+	root := newTreeNode(s.repository)
+	for i, prefix := range s.split.Prefixes {
+		if entries[i] == nil {
+			continue
+		}
+		from, to := parsePrefix(prefix)
+		// A file moved to a directory keeps its name
+		if entries[i].Type != git.ObjectTree && (to == "" || strings.HasSuffix(to, "/")) {
+			to = path.Join(to, path.Base(strings.Trim(from, "/")))
+		}
+		if err := root.Insert(to, entries[i].Id, entries[i].Filemode, false); err != nil {
+			return nil, errors.Wrapf(err, "failed to move prefix %s", prefix)
+		}
+	}
+
+	if !root.isEmpty() {
+		for i, include := range s.split.Include {
+			if includeEntries[i] == nil {
+				continue
+			}
+			if err := s.insertInclude(root, include, includeEntries[i]); err != nil {
+				return nil, errors.Wrapf(err, "failed to include %s", include)
+			}
+		}
+	}
+
+	treeId, err := root.Write()
+	if err != nil {
+		return nil, err
+	}
+	if treeId != nil && len(s.split.Exclude) > 0 {
+		if treeId, err = s.excludePaths(treeId); err != nil {
+			return nil, err
+		}
+	}
+	if treeId != nil && overlayEntry != nil {
+		// The overlay replaces the files of the split
+		root := &treeNode{
+			repository: s.repository,
+			id:         treeId,
+			mode:       git.FilemodeTree,
+		}
+		if err := root.Insert("", overlayEntry.Id, overlayEntry.Filemode, true); err != nil {
+			return nil, errors.Wrapf(err, "failed to apply overlay %s", s.split.Overlay)
+		}
+		if treeId, err = root.Write(); err != nil {
+			return nil, err
+		}
+	}
+	s.trees[key] = treeId
+
+	return treeId, nil
+}
+
+// getIncludeEntry returns the entry of the source tree an include pattern
+// applies to: the file or directory itself, or the deepest directory
+// containing every file matched by the glob.
+func (s *nativeSplitState) getIncludeEntry(tree *git.Tree, include string) *git.TreeEntry {
+	include = strings.Trim(include, "/")
+	base := include
+	if utils.IsGlob(include) {
+		base = utils.GlobBase(include)
+		if base == "" {
+			return &git.TreeEntry{
+				Id:       tree.Id(),
+				Type:     git.ObjectTree,
+				Filemode: git.FilemodeTree,
+			}
+		}
+	}
+
+	entry, err := tree.EntryByPath(base)
+	if err != nil {
+		return nil
+	}
+
+	return entry
+}
+
+// insertInclude copies the files matched by the include pattern at the same
+// path in the split tree. Files provided by the prefixes are kept.
+func (s *nativeSplitState) insertInclude(root *treeNode, include string, entry *git.TreeEntry) error {
+	include = strings.Trim(include, "/")
+	if !utils.IsGlob(include) {
+		if exists, err := root.Exists(include); err != nil || exists {
+			return err
+		}
+
+		return root.Insert(include, entry.Id, entry.Filemode, false)
+	}
+
+	tree, err := s.repository.LookupTree(entry.Id)
+	if err != nil {
+		// The base of the glob is not a directory
+		return nil
+	}
+	defer tree.Free()
+
+	base := utils.GlobBase(include)
+
+	// Without "**", files deeper than the pattern can not match
+	maxDepth := -1
+	if !strings.Contains(include, "**") {
+		maxDepth = strings.Count(include, "/") - strings.Count(base, "/")
+		if base == "" {
+			maxDepth++
+		}
+	}
+
+	includeRegexp := utils.CompileGlob(include)
+	var walkErr error
+	err = tree.Walk(func(directory string, child *git.TreeEntry) int {
+		if child.Type == git.ObjectTree {
+			if maxDepth >= 0 && strings.Count(directory, "/")+1 >= maxDepth {
+				return 1
+			}
+			return 0
+		}
+
+		childPath := path.Join(base, directory, child.Name)
+		if !includeRegexp.MatchString(childPath) {
+			return 0
+		}
+		exists, err := root.Exists(childPath)
+		if err != nil {
+			walkErr = err
+			return -1
+		}
+		if exists {
+			return 0
+		}
+		if err := root.Insert(childPath, child.Id, child.Filemode, false); err != nil {
+			walkErr = err
+			return -1
+		}
+
+		return 0
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+
+	return err
+}
+
+// excludePaths removes the entries matching the exclude patterns of the split.
+func (s *nativeSplitState) excludePaths(treeId *git.Oid) (*git.Oid, error) {
+	tree, err := s.repository.LookupTree(treeId)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find tree %s", treeId)
+	}
+	defer tree.Free()
+
+	excluded := []string{}
+	err = tree.Walk(func(directory string, entry *git.TreeEntry) int {
+		entryPath := directory + entry.Name
+		if matchPathFilters(s.excludeFilters, entryPath, entry.Type == git.ObjectTree) {
+			excluded = append(excluded, entryPath)
+			// Do not walk into excluded directories
+			return 1
+		}
+
+		return 0
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to walk tree")
+	}
+	if len(excluded) == 0 {
+		return treeId, nil
+	}
+
+	root := &treeNode{
+		repository: s.repository,
+		id:         treeId,
+		mode:       git.FilemodeTree,
+	}
+	for _, entryPath := range excluded {
+		if err := root.Remove(entryPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return root.Write()
+}
@@ -0,0 +1,290 @@
+package gitsplit
+
+import (
+	"bufio"
+	"github.com/libgit2/git2go"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRepository(t *testing.T) *git.Repository {
+	repository, err := git.InitRepository(t.TempDir(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(repository.Free)
+
+	return repository
+}
+
+// commitFiles creates a commit holding the given files, indexed by path.
+func commitFiles(t *testing.T, repository *git.Repository, message string, files map[string]string, parents ...*git.Oid) *git.Oid {
+	root := newTreeNode(repository)
+	for filePath, content := range files {
+		blobId, err := repository.CreateBlobFromBuffer([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := root.Insert(filePath, blobId, git.FilemodeBlob, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	treeId, err := root.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := repository.LookupTree(treeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Free()
+
+	parentCommits := []*git.Commit{}
+	for _, parentId := range parents {
+		parent, err := repository.LookupCommit(parentId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer parent.Free()
+		parentCommits = append(parentCommits, parent)
+	}
+
+	signature := &git.Signature{
+		Name:  "gitsplit",
+		Email: "gitsplit@example.com",
+		When:  time.Unix(1500000000, 0),
+	}
+	id, err := repository.CreateCommit("", signature, signature, message, tree, parentCommits...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func setTestReference(t *testing.T, repository *git.Repository, name string, id *git.Oid) {
+	reference, err := repository.References.Create(name, id, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reference.Free()
+}
+
+func getTestParentIds(t *testing.T, repository *git.Repository, id *git.Oid) []*git.Oid {
+	commit, err := repository.LookupCommit(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer commit.Free()
+
+	parentIds := []*git.Oid{}
+	for i := uint(0); i < commit.ParentCount(); i++ {
+		parentIds = append(parentIds, commit.ParentId(i))
+	}
+
+	return parentIds
+}
+
+func countLines(t *testing.T, filePath string) int {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		count++
+	}
+
+	return count
+}
+
+// splitOlderBranchMerge splits a reference, then merges in it a branch forked
+// before the split.
+func splitOlderBranchMerge(t *testing.T, repository *git.Repository) (map[string]*git.Oid, Split) {
+	split := Split{Prefixes: PrefixCollection{"src/partA"}}
+	commits := map[string]*git.Oid{}
+	commits["root"] = commitFiles(t, repository, "root", map[string]string{"src/partA/file": "1", "README": "1"})
+	commits["main"] = commitFiles(t, repository, "main", map[string]string{"src/partA/file": "2", "README": "1"}, commits["root"])
+	commits["branch"] = commitFiles(t, repository, "branch", map[string]string{"src/partA/file": "1", "src/partA/feature": "1", "README": "1"}, commits["root"])
+	commits["merge"] = commitFiles(t, repository, "merge", map[string]string{"src/partA/file": "2", "src/partA/feature": "1", "README": "1"}, commits["main"], commits["branch"])
+
+	return commits, split
+}
+
+func TestReferenceSplitterNativeResumesMergeOfOlderBranch(t *testing.T) {
+	repository := newTestRepository(t)
+	mapPath := filepath.Join(t.TempDir(), "gitsplit.splits")
+	commits, split := splitOlderBranchMerge(t, repository)
+
+	setTestReference(t, repository, "refs/heads/main", commits["main"])
+	mainSplitId, err := NewReferenceSplitterNative(repository, nil, NewSplitMap(mapPath)).Split("refs/heads/main", split, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := countLines(t, mapPath); count != 2 {
+		t.Fatalf("expected 2 commits in the split map, got %d", count)
+	}
+	rootSplitId := getTestParentIds(t, repository, mainSplitId)[0]
+
+	// A later run only splits the merge and the commit of the branch
+	setTestReference(t, repository, "refs/heads/main", commits["merge"])
+	previous := &CacheItem{sourceId: commits["main"], targetId: mainSplitId}
+	mergeSplitId, err := NewReferenceSplitterNative(repository, nil, NewSplitMap(mapPath)).Split("refs/heads/main", split, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := countLines(t, mapPath); count != 4 {
+		t.Errorf("expected 4 commits in the split map, got %d", count)
+	}
+
+	parentIds := getTestParentIds(t, repository, mergeSplitId)
+	if len(parentIds) != 2 || !parentIds[0].Equal(mainSplitId) {
+		t.Fatalf("expected the split of the merge to follow %s, got %v", mainSplitId, parentIds)
+	}
+	branchParentIds := getTestParentIds(t, repository, parentIds[1])
+	if len(branchParentIds) != 1 || !branchParentIds[0].Equal(rootSplitId) {
+		t.Errorf("expected the split of the branch to follow %s, got %v", rootSplitId, branchParentIds)
+	}
+}
+
+func TestReferenceSplitterNativeResume(t *testing.T) {
+	repository := newTestRepository(t)
+	commits, split := splitOlderBranchMerge(t, repository)
+	commits["next"] = commitFiles(t, repository, "next", map[string]string{"src/partA/file": "3", "README": "1"}, commits["main"])
+
+	setTestReference(t, repository, "refs/heads/main", commits["main"])
+	mainSplitId, err := NewReferenceSplitterNative(repository, nil, nil).Split("refs/heads/main", split, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := &CacheItem{sourceId: commits["main"], targetId: mainSplitId}
+
+	setTestReference(t, repository, "refs/heads/main", commits["next"])
+	nextSplitId, err := NewReferenceSplitterNative(repository, nil, nil).Resume("refs/heads/main", split, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parentIds := getTestParentIds(t, repository, nextSplitId); len(parentIds) != 1 || !parentIds[0].Equal(mainSplitId) {
+		t.Errorf("expected the split to follow %s, got %v", mainSplitId, parentIds)
+	}
+
+	// Without split map, the split of the fork point of the branch is unknown
+	setTestReference(t, repository, "refs/heads/main", commits["merge"])
+	_, err = NewReferenceSplitterNative(repository, nil, nil).Resume("refs/heads/main", split, previous)
+	if errors.Cause(err) != errUnknownHistory {
+		t.Errorf("expected %q, got %v", errUnknownHistory, err)
+	}
+
+	// A previous split built with other rules is not resumed
+	previous = &CacheItem{sourceId: commits["main"], targetId: commits["main"]}
+	setTestReference(t, repository, "refs/heads/main", commits["next"])
+	_, err = NewReferenceSplitterNative(repository, nil, nil).Resume("refs/heads/main", split, previous)
+	if errors.Cause(err) != errUnknownHistory {
+		t.Errorf("expected %q, got %v", errUnknownHistory, err)
+	}
+}
+
+func TestReferenceSplitterNativeInclude(t *testing.T) {
+	repository := newTestRepository(t)
+	split := Split{
+		Prefixes: PrefixCollection{"src/partA"},
+		Include:  StringCollection{"*.md", "docs/*/index.md"},
+	}
+	commitId := commitFiles(t, repository, "root", map[string]string{
+		"src/partA/file":         "1",
+		"README.md":              "1",
+		"docs/guide.md":          "1",
+		"docs/api/index.md":      "1",
+		"docs/api/deep/index.md": "1",
+	})
+	setTestReference(t, repository, "refs/heads/main", commitId)
+
+	splitId, err := NewReferenceSplitterNative(repository, nil, nil).Split("refs/heads/main", split, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repository.LookupCommit(splitId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer commit.Free()
+	tree, err := commit.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Free()
+
+	files := []string{}
+	err = tree.Walk(func(directory string, entry *git.TreeEntry) int {
+		if entry.Type == git.ObjectBlob {
+			files = append(files, directory+entry.Name)
+		}
+		return 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "README.md docs/api/index.md file"
+	if got := strings.Join(files, " "); got != expected {
+		t.Errorf("expected the files %q, got %q", expected, got)
+	}
+}
+
+func TestReferenceSplitterNativeFindIdenticalParent(t *testing.T) {
+	repository := newTestRepository(t)
+	state, err := NewReferenceSplitterNative(repository, nil, nil).getState(Split{Prefixes: PrefixCollection{"src/partA"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one := map[string]string{"file": "1"}
+	two := map[string]string{"file": "2"}
+	commits := map[string]*git.Oid{}
+	commits["root"] = commitFiles(t, repository, "root", one)
+	commits["same"] = commitFiles(t, repository, "same", one, commits["root"])
+	commits["other"] = commitFiles(t, repository, "other", two, commits["root"])
+	commits["revert"] = commitFiles(t, repository, "revert", one, commits["other"])
+	commits["unrelated"] = commitFiles(t, repository, "unrelated", one)
+	treeId := writeTestTree(t, repository, one)
+
+	cases := []struct {
+		name     string
+		treeId   *git.Oid
+		parents  []string
+		expected string
+	}{
+		{"identical parent", treeId, []string{"root"}, "root"},
+		{"changed tree", writeTestTree(t, repository, map[string]string{"file": "3"}), []string{"root"}, ""},
+		{"most recent identical parent", treeId, []string{"root", "same"}, "same"},
+		{"most recent identical parent first", treeId, []string{"same", "root"}, "same"},
+		{"identical parents without common history", treeId, []string{"same", "unrelated"}, ""},
+		{"merge of another branch", treeId, []string{"same", "other"}, ""},
+		{"merge of an ancestor", treeId, []string{"revert", "other"}, "revert"},
+	}
+	for _, c := range cases {
+		parents := []*git.Oid{}
+		for _, parent := range c.parents {
+			parents = append(parents, commits[parent])
+		}
+
+		identical, err := state.findIdenticalParent(c.treeId, parents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.expected == "" && identical != nil {
+			t.Errorf("%s: expected no identical parent, got %s", c.name, identical)
+		} else if c.expected != "" && (identical == nil || !identical.Equal(commits[c.expected])) {
+			t.Errorf("%s: expected the parent %s, got %v", c.name, c.expected, identical)
+		}
+	}
+}
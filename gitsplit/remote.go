@@ -19,6 +19,7 @@ type GitRemoteCollection struct {
 	repository      *git.Repository
 	items           map[string]*GitRemote
 	mutexRemoteList *sync.Mutex
+	dryRun          bool
 }
 
 func NewGitRemoteCollection(repository *git.Repository) *GitRemoteCollection {
@@ -31,6 +32,7 @@ func NewGitRemoteCollection(repository *git.Repository) *GitRemoteCollection {
 
 func (r *GitRemoteCollection) Add(alias string, url string, refs []string) *GitRemote {
 	remote := NewGitRemote(r.repository, alias, url, refs)
+	remote.dryRun = r.dryRun
 	r.items[alias] = remote
 
 	r.mutexRemoteList.Lock()
@@ -49,6 +51,13 @@ func (r *GitRemoteCollection) Get(alias string) (*GitRemote, error) {
 
 }
 
+func (r *GitRemoteCollection) SetDryRun(dryRun bool) {
+	r.dryRun = dryRun
+	for _, remote := range r.items {
+		remote.dryRun = dryRun
+	}
+}
+
 func (r *GitRemoteCollection) Clean() {
 	knownRemotes := []string{}
 	for _, remote := range r.items {
@@ -74,13 +83,14 @@ func (r *GitRemoteCollection) Clean() {
 }
 
 func (r *GitRemoteCollection) Flush() error {
+	errs := utils.Errors{}
 	for _, remote := range r.items {
 		if err := remote.Flush(); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
 
-	return nil
+	return errs.ErrorOrNil()
 }
 
 type GitRemote struct {
@@ -93,6 +103,25 @@ type GitRemote struct {
 	pool            *utils.Pool
 	cacheReferences []Reference
 	mutexReferences *sync.Mutex
+	dryRun          bool
+}
+
+type PushStatus string
+
+const (
+	PushStatusPending  PushStatus = "pending"
+	PushStatusPushed   PushStatus = "pushed"
+	PushStatusSkipped  PushStatus = "skipped"
+	PushStatusUpToDate PushStatus = "up-to-date"
+	PushStatusFailed   PushStatus = "failed"
+)
+
+type PushResult struct {
+	Remote  string
+	Refspec string
+	Policy  PushPolicy
+	Status  PushStatus
+	Error   error
 }
 
 func NewGitRemote(repository *git.Repository, alias string, url string, refs []string) *GitRemote {
@@ -163,6 +192,26 @@ func (r *GitRemote) AddReference(alias string, id *git.Oid) error {
 	return nil
 }
 
+func (r *GitRemote) RemoveReference(alias string) error {
+	r.mutexReferences.Lock()
+	defer r.mutexReferences.Unlock()
+
+	r.cacheReferences = nil
+	for _, ref := range r.refs {
+		reference, err := r.repository.References.Lookup(fmt.Sprintf("refs/remotes/%s/%s/%s", r.id, ref, alias))
+		if err != nil {
+			continue
+		}
+		defer reference.Free()
+		if err := reference.Delete(); err != nil {
+			return errors.Wrap(err, "failed to remove reference")
+		}
+		r.pushRef(fmt.Sprintf(":refs/%s/%s", ref, alias), nil)
+	}
+
+	return nil
+}
+
 func (r *GitRemote) GetReferences() ([]Reference, error) {
 	r.mutexReferences.Lock()
 	defer r.mutexReferences.Unlock()
@@ -210,7 +259,8 @@ func (r *GitRemote) getRemoteReferences() ([]Reference, error) {
 		referenceId := columns[0]
 		referenceName := columns[1]
 
-		if !filterRegexp.MatchString(referenceName) {
+		// Skip the peeled "refs/tags/x^{}" entries of annotated tags
+		if !filterRegexp.MatchString(referenceName) || strings.HasSuffix(referenceName, "^{}") {
 			continue
 		}
 
@@ -278,16 +328,47 @@ func (r *GitRemote) Fetch() {
 }
 
 func (r *GitRemote) PushRef(refs string) {
+	r.pushRef(refs, nil, "--force")
+}
+
+func (r *GitRemote) pushRef(refs string, result *PushResult, options ...string) {
+	if r.dryRun {
+		log.WithFields(log.Fields{
+		    "remote": r.alias,
+		    "refs": refs,
+		    "options": options,
+		}).Info("Dry run: skip pushing to remote")
+		if result != nil {
+			result.Status = PushStatusSkipped
+		}
+		return
+	}
+
 	r.pool.Push(func() (interface{}, error) {
 		log.WithFields(log.Fields{
 		    "remote": r.alias,
 		    "refs": refs,
+		    "options": options,
 		}).Warn("Pushing to remote")
-		if _, err := utils.GitExec(r.repository.Path(), "push", "--force", r.id, refs); err != nil {
-			return nil, errors.Wrapf(err, "failed to push reference %s", refs)
+		execResult, err := utils.GitExec(r.repository.Path(), "push", append(options, r.id, refs)...)
+		if err != nil {
+			if strings.Contains(execResult.Output, "[rejected]") {
+				parts := strings.SplitN(refs, ":", 2)
+				err = errors.Wrapf(err, "reference %s diverged on remote %s", parts[len(parts)-1], r.alias)
+			} else {
+				err = errors.Wrapf(err, "failed to push reference %s", refs)
+			}
 		}
 
-		return nil, nil
+		if result != nil {
+			result.Status = PushStatusPushed
+			if err != nil {
+				result.Status = PushStatusFailed
+				result.Error = err
+			}
+		}
+
+		return nil, err
 	})
 }
 
@@ -297,34 +378,81 @@ func (r *GitRemote) PushAll() {
 	}
 }
 
-func (r *GitRemote) Push(reference Reference, splitId *git.Oid) error {
+// Push pushes the split in the remote. The result is returned even when the
+// push can not be attempted, with the status failed.
+func (r *GitRemote) Push(reference Reference, splitId *git.Oid, policy PushPolicy) (*PushResult, error) {
+	result := &PushResult{
+		Remote:  r.alias,
+		Refspec: splitId.String() + ":refs/" + reference.ShortName,
+		Policy:  policy,
+		Status:  PushStatusPending,
+	}
+
 	references, err := r.GetReferences()
 	if err != nil {
-		return errors.Wrapf(err, "failed to get references for remote %s", r.alias)
+		result.Status = PushStatusFailed
+		result.Error = errors.Wrapf(err, "failed to get references for remote %s", r.alias)
+		return result, result.Error
 	}
 
+	var remoteId *git.Oid
 	for _, remoteReference := range references {
-		if remoteReference.Alias == reference.Alias {
+		if remoteReference.ShortName == reference.ShortName {
 			if remoteReference.Id.Equal(splitId) {
 				log.WithFields(log.Fields{
 				    "remote": r.alias,
 				}).Info("Already pushed " + reference.Alias)
-				return nil
+				result.Status = PushStatusUpToDate
+				return result, nil
 			}
 			log.WithFields(log.Fields{
 			    "remote": r.alias,
+			    "policy": policy,
 			}).Warn("Out of date " + reference.Alias)
+			remoteId = remoteReference.Id
 			break
 		}
 	}
 
-	r.PushRef(splitId.String() + ":refs/" + reference.ShortName)
+	switch policy {
+	case PushPolicyFastForwardOnly:
+		r.pushRef(result.Refspec, result)
+	case PushPolicyForceWithLease:
+		// An empty lease means that the reference must not exist on the remote
+		lease := ""
+		if remoteId != nil {
+			lease = remoteId.String()
+		}
+		r.pushRef(result.Refspec, result, fmt.Sprintf("--force-with-lease=refs/%s:%s", reference.ShortName, lease))
+	default:
+		r.pushRef(result.Refspec, result, "--force")
+	}
 
-	return nil
+	return result, nil
+}
+
+func (r *GitRemote) Delete(reference Reference, policy PushPolicy) *PushResult {
+	result := &PushResult{
+		Remote:  r.alias,
+		Refspec: ":refs/" + reference.ShortName,
+		Policy:  policy,
+		Status:  PushStatusPending,
+	}
+
+	log.WithFields(log.Fields{
+	    "remote": r.alias,
+	}).Warn("Pruning " + reference.Alias)
+	if policy == PushPolicyForceWithLease {
+		r.pushRef(result.Refspec, result, fmt.Sprintf("--force-with-lease=refs/%s:%s", reference.ShortName, reference.Id))
+	} else {
+		r.pushRef(result.Refspec, result)
+	}
+
+	return result
 }
 
 func (r *GitRemote) FetchFile(referenceName string, fileName string, filePath string) error {
-	reference, err := r.GetReference("splitsh")
+	reference, err := r.GetReference(referenceName)
 	if err != nil {
 		return errors.Wrapf(err, "failed to fetch file reference %s", referenceName)
 	}
@@ -343,7 +471,8 @@ func (r *GitRemote) FetchFile(referenceName string, fileName string, filePath st
 	defer tree.Free()
 	entry, err := tree.EntryByPath(fileName)
 	if err != nil {
-		return errors.Wrapf(err, "failed to fetch file from tree")
+		// The file has never been pushed
+		return nil
 	}
 
 	odb, err := r.repository.Odb()
@@ -363,8 +492,47 @@ func (r *GitRemote) FetchFile(referenceName string, fileName string, filePath st
 	return nil
 }
 
+// ListFiles returns the names of the files stored in the reference.
+func (r *GitRemote) ListFiles(referenceName string) ([]string, error) {
+	reference, err := r.GetReference(referenceName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch file reference %s", referenceName)
+	}
+	if reference == nil {
+		return []string{}, nil
+	}
+	commit, err := r.repository.LookupCommit(reference.Id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find commit %s", reference.Id)
+	}
+	defer commit.Free()
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch commit tree")
+	}
+	defer tree.Free()
+
+	fileNames := []string{}
+	for i := uint64(0); i < tree.EntryCount(); i++ {
+		fileNames = append(fileNames, tree.EntryByIndex(i).Name)
+	}
+
+	return fileNames, nil
+}
+
 func (r *GitRemote) PushFile(fileName string, filePath string, message string, referenceName string) error {
-	treeBuilder, err := r.repository.TreeBuilder()
+	reference, err := r.GetReference(referenceName)
+	if err != nil {
+		reference = nil
+	}
+
+	// Keep the other files stored in the reference
+	var treeBuilder *git.TreeBuilder
+	if reference != nil {
+		treeBuilder, err = r.getTreeBuilder(reference)
+	} else {
+		treeBuilder, err = r.repository.TreeBuilder()
+	}
 	if err != nil {
 		return errors.Wrap(err, "failed to create treeBuilder")
 	}
@@ -402,14 +570,28 @@ func (r *GitRemote) PushFile(fileName string, filePath string, message string, r
 	}
 	defer tree.Free()
 
-	reference, err := r.GetReference(referenceName)
-	if err == nil && reference != nil {
+	if reference != nil {
 		return r.replaceFile(reference, message, tree)
 	}
 
 	return r.insertFile(referenceName, message, tree)
 }
 
+func (r *GitRemote) getTreeBuilder(reference *Reference) (*git.TreeBuilder, error) {
+	commit, err := r.repository.LookupCommit(reference.Id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find commit %s", reference.Id)
+	}
+	defer commit.Free()
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch commit tree")
+	}
+	defer tree.Free()
+
+	return r.repository.TreeBuilderFromTree(tree)
+}
+
 func (r *GitRemote) GetSignature() *git.Signature {
 	return &git.Signature{
 		Name:  "gitsplit",
@@ -453,9 +635,6 @@ func (r *GitRemote) insertFile(referenceName string, message string, tree *git.T
 
 func (r *GitRemote) Flush() error {
 	results := r.pool.Wait()
-	if err := results.FirstError(); err != nil {
-		return err
-	}
 
-	return nil
+	return results.Errors().ErrorOrNil()
 }
@@ -0,0 +1,173 @@
+package gitsplit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jderusse/gitsplit/utils"
+	"github.com/libgit2/git2go"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+type ReportItem struct {
+	Reference Reference
+	Split     Split
+	Fresh     bool
+	SplitId   *git.Oid
+	Pushes    []*PushResult
+	Pruned    bool
+	Duration  time.Duration
+	Error     error
+}
+
+type Report struct {
+	items      []*ReportItem
+	mutexItems *sync.Mutex
+}
+
+type jsonReportPush struct {
+	Target  string     `json:"target"`
+	Refspec string     `json:"refspec"`
+	Policy  PushPolicy `json:"policy"`
+	Status  PushStatus `json:"status"`
+	Error   string     `json:"error,omitempty"`
+}
+
+type jsonReportItem struct {
+	Reference  string           `json:"reference"`
+	Name       string           `json:"name,omitempty"`
+	Prefixes   []string         `json:"prefixes"`
+	SourceId   string           `json:"source_id"`
+	SplitId    string           `json:"split_id,omitempty"`
+	CacheHit   bool             `json:"cache_hit"`
+	Pruned     bool             `json:"pruned"`
+	Targets    []jsonReportPush `json:"targets"`
+	DurationMs int64            `json:"duration_ms"`
+	Error      string           `json:"error,omitempty"`
+}
+
+func NewReport() *Report {
+	return &Report{
+		items:      []*ReportItem{},
+		mutexItems: &sync.Mutex{},
+	}
+}
+
+func (r *Report) Add(item *ReportItem) {
+	r.mutexItems.Lock()
+	defer r.mutexItems.Unlock()
+
+	r.items = append(r.items, item)
+}
+
+// Items returns the report items sorted by reference and split, whatever the
+// order in which they were processed.
+func (r *Report) Items() []*ReportItem {
+	r.mutexItems.Lock()
+	defer r.mutexItems.Unlock()
+
+	items := make([]*ReportItem, len(r.items))
+	copy(items, r.items)
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Reference.Name != items[j].Reference.Name {
+			return items[i].Reference.Name < items[j].Reference.Name
+		}
+
+		return items[i].Split.DisplayName() < items[j].Split.DisplayName()
+	})
+
+	return items
+}
+
+func (r *Report) Print(writer io.Writer) {
+	lastReference := ""
+	for _, item := range r.Items() {
+		if item.Reference.Name != lastReference {
+			fmt.Fprintf(writer, "Reference %s (%s)\n", item.Reference.Alias, item.Reference.Id)
+			lastReference = item.Reference.Name
+		}
+
+		if item.Pruned {
+			for _, push := range item.Pushes {
+				fmt.Fprintf(writer, "  Prune %s from %s (split %s)\n", item.Reference.ShortName, push.Remote, item.Split.DisplayName())
+			}
+			continue
+		}
+
+		cache := "cache miss"
+		if item.Fresh {
+			cache = "cache hit"
+		}
+		fmt.Fprintf(writer, "  Split %s (%s)\n", item.Split.DisplayName(), cache)
+
+		if item.Error != nil {
+			fmt.Fprintf(writer, "    Failed: %s\n", item.Error)
+			continue
+		}
+		if item.SplitId == nil {
+			fmt.Fprintf(writer, "    Prefix not found, nothing to push\n")
+			continue
+		}
+		fmt.Fprintf(writer, "    Split id %s\n", item.SplitId)
+
+		for _, push := range item.Pushes {
+			if push.Status == PushStatusFailed {
+				fmt.Fprintf(writer, "    %s: failed: %s\n", push.Remote, push.Error)
+			} else if push.Status == PushStatusUpToDate {
+				fmt.Fprintf(writer, "    %s: already up to date\n", push.Remote)
+			} else {
+				fmt.Fprintf(writer, "    %s: push %s (%s)\n", push.Remote, push.Refspec, push.Policy)
+			}
+		}
+	}
+}
+
+func (r *Report) Dump(filePath string) error {
+	items := []jsonReportItem{}
+	for _, item := range r.Items() {
+		jsonItem := jsonReportItem{
+			Reference:  item.Reference.Alias,
+			Name:       item.Split.Name,
+			Prefixes:   item.Split.Prefixes,
+			SourceId:   item.Reference.Id.String(),
+			CacheHit:   item.Fresh,
+			Pruned:     item.Pruned,
+			Targets:    []jsonReportPush{},
+			DurationMs: int64(item.Duration / time.Millisecond),
+		}
+		if item.SplitId != nil {
+			jsonItem.SplitId = item.SplitId.String()
+		}
+		if item.Error != nil {
+			jsonItem.Error = item.Error.Error()
+		}
+		for _, push := range item.Pushes {
+			jsonPush := jsonReportPush{
+				Target:  push.Remote,
+				Refspec: push.Refspec,
+				Policy:  push.Policy,
+				Status:  push.Status,
+			}
+			if push.Error != nil {
+				jsonPush.Error = push.Error.Error()
+			}
+			jsonItem.Targets = append(jsonItem.Targets, jsonPush)
+		}
+		items = append(items, jsonItem)
+	}
+
+	content, err := json.MarshalIndent(map[string]interface{}{"items": items}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode report")
+	}
+
+	if err := ioutil.WriteFile(utils.ResolvePath(filePath), content, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write report %s", filePath)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package gitsplit
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jderusse/gitsplit/utils"
+	"github.com/libgit2/git2go"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SplitMap records, for each split, the split commit of every source commit
+// rewritten by the native backend. The file is stored in the cache next to
+// splitsh.db, so that later runs never rewrite a commit twice, even when a
+// reference merges a branch forked before the previous split.
+type SplitMap struct {
+	filePath string
+	splits   map[string]map[git.Oid]*git.Oid
+	mutex    *sync.Mutex
+}
+
+// splitMapNone marks source commits in which the prefixes do not exist.
+const splitMapNone = "-"
+
+func NewSplitMap(filePath string) *SplitMap {
+	return &SplitMap{
+		filePath: filePath,
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// Commits returns the split commits of the split indexed by source commit. A
+// nil split commit means that the prefixes do not exist in the source commit.
+// The caller owns the returned map, which is not kept in memory twice.
+func (m *SplitMap) Commits(split Split) (map[git.Oid]*git.Oid, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	key := utils.Hash(split.cacheKey())
+	commits, ok := m.splits[key]
+	if !ok {
+		return make(map[git.Oid]*git.Oid), nil
+	}
+	delete(m.splits, key)
+
+	return commits, nil
+}
+
+// Add records the split commits of the split indexed by source commit.
+func (m *SplitMap) Add(split Split, commits map[git.Oid]*git.Oid) error {
+	if len(commits) == 0 {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Commits added by this run are already known by their split state
+	if err := m.load(); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(m.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open split map %s", m.filePath)
+	}
+	defer file.Close()
+
+	key := utils.Hash(split.cacheKey())
+	writer := bufio.NewWriter(file)
+	for sourceId, splitId := range commits {
+		value := splitMapNone
+		if splitId != nil {
+			value = splitId.String()
+		}
+		if _, err := fmt.Fprintf(writer, "%s %s %s\n", key, sourceId.String(), value); err != nil {
+			return errors.Wrapf(err, "failed to write split map %s", m.filePath)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return errors.Wrapf(err, "failed to write split map %s", m.filePath)
+	}
+
+	return nil
+}
+
+// load reads the file once, the cache has to be loaded before splitting.
+func (m *SplitMap) load() error {
+	if m.splits != nil {
+		return nil
+	}
+
+	m.splits = make(map[string]map[git.Oid]*git.Oid)
+	file, err := os.Open(m.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to open split map %s", m.filePath)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		columns := strings.Fields(scanner.Text())
+		if len(columns) != 3 {
+			return fmt.Errorf("failed to parse split map %s: 3 columns expected in %q", m.filePath, scanner.Text())
+		}
+		sourceId, err := git.NewOid(columns[1])
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse split map %s", m.filePath)
+		}
+		var splitId *git.Oid
+		if columns[2] != splitMapNone {
+			if splitId, err = git.NewOid(columns[2]); err != nil {
+				return errors.Wrapf(err, "failed to parse split map %s", m.filePath)
+			}
+		}
+
+		if m.splits[columns[0]] == nil {
+			m.splits[columns[0]] = make(map[git.Oid]*git.Oid)
+		}
+		m.splits[columns[0]][*sourceId] = splitId
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "failed to read split map %s", m.filePath)
+	}
+
+	return nil
+}
@@ -1,12 +1,16 @@
 package gitsplit
 
 import (
+	"fmt"
 	"github.com/jderusse/gitsplit/utils"
 	"github.com/libgit2/git2go"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type Reference struct {
@@ -17,22 +21,75 @@ type Reference struct {
 }
 
 type Splitter struct {
-	config            Config
-	referenceSplitter *ReferenceSplitterLite
-	workingSpace      *WorkingSpace
-	cachePool         CachePoolInterface
+	config             Config
+	referenceSplitters map[Backend]ReferenceSplitter
+	workingSpace       *WorkingSpace
+	cachePool          CachePoolInterface
+	report             *Report
+	keepGoing          bool
+	whitelistSplits    []string
+	jobs               uint
+	tempCounter        uint64
 }
 
 func NewSplitter(config Config, workingSpace *WorkingSpace, cachePool CachePoolInterface) *Splitter {
+	commitMap := NewCommitMap(filepath.Join(workingSpace.Repository().Path(), "gitsplit.map"))
+	splitMap := NewSplitMap(filepath.Join(workingSpace.Repository().Path(), "gitsplit.splits"))
+
 	return &Splitter{
-		config:            config,
-		workingSpace:      workingSpace,
-		referenceSplitter: NewReferenceSplitterLite(workingSpace.Repository()),
-		cachePool:         cachePool,
+		config:       config,
+		workingSpace: workingSpace,
+		referenceSplitters: map[Backend]ReferenceSplitter{
+			BackendLite:   NewReferenceSplitter(BackendLite, workingSpace.Repository(), commitMap, splitMap),
+			BackendNative: NewReferenceSplitter(BackendNative, workingSpace.Repository(), commitMap, splitMap),
+		},
+		cachePool: cachePool,
+		report:    NewReport(),
+		jobs:      1,
+	}
+}
+
+func (s *Splitter) Report() *Report {
+	return s.report
+}
+
+// SetKeepGoing makes the splitter process every reference, split and target
+// even when some of them fail. Failures are returned as a single aggregated error.
+func (s *Splitter) SetKeepGoing(keepGoing bool) {
+	s.keepGoing = keepGoing
+}
+
+// SetJobs defines how many (reference, split) pairs are processed
+// concurrently. References of the same split are split one at a time, as
+// they share the cache of the split.
+func (s *Splitter) SetJobs(jobs uint) {
+	if jobs < 1 {
+		jobs = 1
 	}
+	s.jobs = jobs
+}
+
+// SetWhitelistSplits restricts the splits to process to the given names.
+// Entries prefixed by "!" exclude a split. Unnamed splits are identified by
+// their prefixes.
+func (s *Splitter) SetWhitelistSplits(whitelistSplits []string) {
+	s.whitelistSplits = whitelistSplits
 }
 
 func (s *Splitter) Split(whitelistReferences []string) error {
+	if err := validateWhitelist(whitelistReferences); err != nil {
+		return err
+	}
+	splits, err := s.getSplits()
+	if err != nil {
+		return err
+	}
+	for _, split := range splits {
+		if errs := split.validatePatterns(); len(errs) > 0 {
+			return errors.Wrapf(errs, "invalid split %s", split.DisplayName())
+		}
+	}
+
 	remote, err := s.workingSpace.Remotes().Get("origin")
 	if err != nil {
 		return err
@@ -42,32 +99,79 @@ func (s *Splitter) Split(whitelistReferences []string) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to split references")
 	}
-	for _, reference := range references {
-		for _, referencePattern := range s.config.Origins {
-			referenceRegexp := regexp.MustCompile(referencePattern)
-			if !referenceRegexp.MatchString(reference.Alias) {
+
+	pool := utils.NewPool(s.jobs)
+	defer pool.Close()
+
+	failed := int32(0)
+	for _, split := range splits {
+		for _, reference := range references {
+			if !split.MatchReference(reference) {
 				continue
 			}
-			if len(whitelistReferences) > 0 && !utils.InArray(whitelistReferences, reference.Alias) {
+			if !matchWhitelist(whitelistReferences, reference.Alias) {
 				continue
 			}
 
-			for _, split := range s.config.Splits {
+			reference, split := reference, split
+			pool.Push(func() (interface{}, error) {
+				if !s.keepGoing && atomic.LoadInt32(&failed) != 0 {
+					return nil, nil
+				}
 				if err := s.splitReference(reference, split); err != nil {
-					return errors.Wrap(err, "failed to split references")
+					atomic.StoreInt32(&failed, 1)
+					log.WithFields(log.Fields{
+					    "reference": reference.Alias,
+					    "split": split.DisplayName(),
+					}).Error(err)
+					return nil, errors.Wrapf(err, "failed to split reference %s", reference.Alias)
+				}
+
+				return nil, nil
+			})
+		}
+	}
+
+	results := pool.Wait()
+	errs := results.Errors()
+	if len(errs) > 0 && !s.keepGoing {
+		return errors.Wrap(errs[0], "failed to split references")
+	}
+
+	// Pruning is only safe when every reference has been considered
+	if len(whitelistReferences) == 0 {
+		for _, split := range splits {
+			if !split.Prune {
+				continue
+			}
+			if err := s.pruneSplit(references, split); err != nil {
+				if !s.keepGoing {
+					return errors.Wrap(err, "failed to prune references")
 				}
+				errs = append(errs, errors.Wrap(err, "failed to prune references"))
 			}
 		}
 	}
 
 	if err := s.workingSpace.Remotes().Flush(); err != nil {
-		return errors.Wrap(err, "failed to flush references")
+		errs = append(errs, errors.Wrap(err, "failed to flush references"))
 	}
-	return nil
+
+	return errs.ErrorOrNil()
 }
 
-func (s *Splitter) splitReference(reference Reference, split Split) error {
-	flagTemp := "refs/split-temp/" + utils.Hash(reference.Name) + "-" + utils.Hash(strings.Join(split.Prefixes, "-"))
+func (s *Splitter) splitReference(reference Reference, split Split) (err error) {
+	flagTemp := fmt.Sprintf("refs/split-temp/%s-%s-%d", utils.Hash(reference.Name), split.cacheKey(), atomic.AddUint64(&s.tempCounter, 1))
+
+	reportItem := &ReportItem{
+		Reference: reference,
+		Split:     split,
+	}
+	s.report.Add(reportItem)
+	defer func(start time.Time) {
+		reportItem.Duration = time.Since(start)
+		reportItem.Error = err
+	}(time.Now())
 
 	previousReference, err := s.cachePool.GetItem(reference.Name, split)
 	if err != nil {
@@ -76,11 +180,22 @@ func (s *Splitter) splitReference(reference Reference, split Split) error {
 
 	contextualLog := log.WithFields(log.Fields{
 	    "reference": reference.Alias,
-	    "splits": split.Prefixes,
+	    "split": split.DisplayName(),
 	})
 
-	if previousReference.IsFresh(reference) {
+	reportItem.Fresh = previousReference.IsFresh(reference)
+	var resumePoint *CacheItem
+	if !reportItem.Fresh {
+		resumePoint = s.getResumePoint(reference, previousReference)
+	}
+	if reportItem.Fresh {
 		contextualLog.Info("Already splitted")
+	} else if resumePoint != nil && s.isUnchanged(split, resumePoint.SourceId(), reference.Id) {
+		contextualLog.Info("Prefixes did not change, reusing the previous split")
+		previousReference.Set(reference.Id, resumePoint.TargetId())
+		if err := s.cachePool.SaveItem(previousReference); err != nil {
+			return errors.Wrapf(err, "failed to cache reference %s", reference.Name)
+		}
 	} else {
 		contextualLog.Warn("Splitting")
 		tempReference, err := s.workingSpace.Repository().References.Create(flagTemp, reference.Id, true, "Temporary reference")
@@ -89,7 +204,7 @@ func (s *Splitter) splitReference(reference Reference, split Split) error {
 		}
 		defer tempReference.Free()
 
-		splitId, err := s.referenceSplitter.Split(flagTemp, split.Prefixes)
+		splitId, err := s.referenceSplitters[split.Backend].Split(flagTemp, split, resumePoint)
 		if err != nil {
 			return errors.Wrap(err, "failed to split reference")
 		}
@@ -109,15 +224,220 @@ func (s *Splitter) splitReference(reference Reference, split Split) error {
 	if previousReference.TargetId() == nil {
 		return nil
 	}
+	reportItem.SplitId = previousReference.TargetId()
 
+	errs := utils.Errors{}
 	for _, target := range split.Targets {
 		remote, err := s.workingSpace.Remotes().Get(target)
 		if err != nil {
 			return err
 		}
-		if err := remote.Push(reference, previousReference.TargetId()); err != nil {
+		pushResult, err := remote.Push(split.TargetReference(reference), previousReference.TargetId(), split.PushPolicy)
+		reportItem.Pushes = append(reportItem.Pushes, pushResult)
+		if err != nil {
+			if !s.keepGoing {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// getResumePoint returns the cached split from which the reference can be
+// incrementally split, or nil when the history has been rewritten since.
+func (s *Splitter) getResumePoint(reference Reference, previousReference *CacheItem) *CacheItem {
+	if previousReference.SourceId() == nil || previousReference.TargetId() == nil {
+		return nil
+	}
+
+	isDescendant, err := s.isDescendant(reference.Id, previousReference.SourceId())
+	if err != nil || !isDescendant {
+		log.WithFields(log.Fields{
+		    "reference": reference.Alias,
+		    "source": previousReference.SourceId().String(),
+		}).Info("History has been rewritten, cannot resume the previous split")
+		return nil
+	}
+
+	return previousReference
+}
+
+// isUnchanged tells whether every path used by the split (prefixes, includes
+// and overlay) is identical in both commits, in which case the split of the
+// new commit is the split of the previous one.
+func (s *Splitter) isUnchanged(split Split, previousId *git.Oid, id *git.Oid) bool {
+	previousTree, err := getTree(s.workingSpace.Repository(), previousId)
+	if err != nil {
+		return false
+	}
+	defer previousTree.Free()
+
+	tree, err := getTree(s.workingSpace.Repository(), id)
+	if err != nil {
+		return false
+	}
+	defer tree.Free()
+
+	paths := []string{}
+	for _, prefix := range split.Prefixes {
+		from, _ := parsePrefix(prefix)
+		paths = append(paths, strings.Trim(from, "/"))
+	}
+	for _, include := range split.Include {
+		include = strings.Trim(include, "/")
+		if utils.IsGlob(include) {
+			include = utils.GlobBase(include)
+		}
+		paths = append(paths, include)
+	}
+	if split.Overlay != "" {
+		paths = append(paths, strings.Trim(split.Overlay, "/"))
+	}
+
+	for _, entryPath := range paths {
+		// The glob of an include matches the root of the repository
+		if entryPath == "" {
+			return false
+		}
+
+		previousEntry, previousErr := previousTree.EntryByPath(entryPath)
+		entry, err := tree.EntryByPath(entryPath)
+		if (previousErr == nil) != (err == nil) {
+			return false
+		}
+		if err == nil && (!previousEntry.Id.Equal(entry.Id) || previousEntry.Filemode != entry.Filemode) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isDescendant tells whether the commit (or tag) is a descendant of the
+// ancestor commit (or tag).
+func (s *Splitter) isDescendant(id *git.Oid, ancestorId *git.Oid) (bool, error) {
+	commitId, err := peelCommitId(s.workingSpace.Repository(), id)
+	if err != nil {
+		return false, err
+	}
+	ancestorCommitId, err := peelCommitId(s.workingSpace.Repository(), ancestorId)
+	if err != nil {
+		return false, err
+	}
+
+	return s.workingSpace.Repository().DescendantOf(commitId, ancestorCommitId)
+}
+
+func (s *Splitter) pruneSplit(references []Reference, split Split) error {
+	contextualLog := log.WithFields(log.Fields{
+	    "split": split.DisplayName(),
+	})
+	if len(references) == 0 {
+		contextualLog.Warn("No reference found in origin, skip pruning")
+		return nil
+	}
+
+	referenceNames := []string{}
+	expectedReferences := []string{}
+	for _, reference := range references {
+		referenceNames = append(referenceNames, reference.Name)
+		if split.MatchReference(reference) {
+			expectedReferences = append(expectedReferences, split.TargetReference(reference).ShortName)
+		}
+	}
+
+	for _, target := range split.Targets {
+		remote, err := s.workingSpace.Remotes().Get(target)
+		if err != nil {
 			return err
 		}
+		targetReferences, err := remote.GetReferences()
+		if err != nil {
+			return errors.Wrapf(err, "failed to get references for remote %s", target)
+		}
+		for _, targetReference := range targetReferences {
+			if !split.MatchAlias(targetReference.Alias) || utils.InArray(expectedReferences, targetReference.ShortName) {
+				continue
+			}
+
+			s.report.Add(&ReportItem{
+				Reference: targetReference,
+				Split:     split,
+				Pruned:    true,
+				Pushes:    []*PushResult{remote.Delete(targetReference, split.PushPolicy)},
+			})
+		}
+	}
+
+	if err := s.cachePool.Prune(referenceNames, split); err != nil {
+		return err
+	}
+	contextualLog.Info("Pruned")
+
+	return nil
+}
+
+// getSplits returns the splits selected by the splits given in command line.
+func (s *Splitter) getSplits() ([]Split, error) {
+	if err := validateWhitelist(s.whitelistSplits); err != nil {
+		return nil, err
+	}
+
+	for _, name := range s.whitelistSplits {
+		name = strings.TrimPrefix(name, "!")
+		if strings.HasPrefix(name, "^") || utils.IsGlob(name) {
+			continue
+		}
+		known := false
+		for _, split := range s.config.Splits {
+			if split.DisplayName() == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown split %s", name)
+		}
+	}
+
+	splits := []Split{}
+	for _, split := range s.config.Splits {
+		if matchWhitelist(s.whitelistSplits, split.DisplayName()) {
+			splits = append(splits, split)
+		}
+	}
+
+	return splits, nil
+}
+
+// matchWhitelist tells whether the reference (or split) is selected by the
+// entries given in command line. Entries prefixed by "!" exclude a reference.
+// Entries starting with "^" are regexps, entries containing "*" or "?" are
+// globs, others have to match exactly.
+func matchWhitelist(whitelist []string, alias string) bool {
+	return matchPatterns(whitelist, func(pattern string) bool {
+		switch {
+		case strings.HasPrefix(pattern, "^"):
+			return regexp.MustCompile(pattern).MatchString(alias)
+		case utils.IsGlob(pattern):
+			return utils.MatchGlob(pattern, alias)
+		default:
+			return pattern == alias
+		}
+	})
+}
+
+func validateWhitelist(whitelist []string) error {
+	for _, pattern := range whitelist {
+		pattern = strings.TrimPrefix(pattern, "!")
+		if !strings.HasPrefix(pattern, "^") {
+			continue
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("Invalid pattern %s: %s", pattern, err)
+		}
 	}
 
 	return nil
@@ -0,0 +1,38 @@
+package gitsplit
+
+import (
+	"testing"
+)
+
+func TestMatchWhitelist(t *testing.T) {
+	cases := []struct {
+		whitelist []string
+		alias     string
+		expected  bool
+	}{
+		{[]string{"main"}, "main", true},
+		{[]string{"main"}, "main-old", false},
+		{[]string{"release/*"}, "release/1.0", true},
+		{[]string{"release/*"}, "release/1.0/hotfix", false},
+		{[]string{`^v2\.`}, "v2.1.0", true},
+		{[]string{`^v2\.`}, "v20.1.0", false},
+		{[]string{"release/*", "!release/0.*"}, "release/0.9", false},
+		{[]string{"!main"}, "develop", true},
+		{[]string{"!main"}, "main", false},
+	}
+
+	for _, c := range cases {
+		if got := matchWhitelist(c.whitelist, c.alias); got != c.expected {
+			t.Errorf("expected %v to match %s: %t, got %t", c.whitelist, c.alias, c.expected, got)
+		}
+	}
+}
+
+func TestValidateWhitelist(t *testing.T) {
+	if err := validateWhitelist([]string{"main", "release/*", `!^v2\.`}); err != nil {
+		t.Errorf("expected a valid whitelist, got %v", err)
+	}
+	if err := validateWhitelist([]string{"!^v(2"}); err == nil {
+		t.Errorf("expected an invalid regexp to be reported")
+	}
+}
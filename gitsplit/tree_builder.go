@@ -0,0 +1,239 @@
+package gitsplit
+
+import (
+	"fmt"
+	"github.com/libgit2/git2go"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+)
+
+// treeNode is an in-memory representation of a git tree used to assemble the
+// tree of a split commit from pieces of the source tree. Nodes are loaded
+// lazily: an untouched node is written back as its original object id.
+type treeNode struct {
+	repository *git.Repository
+	id         *git.Oid
+	mode       git.Filemode
+	children   map[string]*treeNode
+}
+
+func newTreeNode(repository *git.Repository) *treeNode {
+	return &treeNode{
+		repository: repository,
+		mode:       git.FilemodeTree,
+		children:   map[string]*treeNode{},
+	}
+}
+
+func (n *treeNode) isTree() bool {
+	return n.mode == git.FilemodeTree
+}
+
+func (n *treeNode) isEmpty() bool {
+	return n.id == nil && len(n.children) == 0
+}
+
+func (n *treeNode) expand() error {
+	if n.children != nil {
+		return nil
+	}
+
+	n.children = map[string]*treeNode{}
+	if n.id == nil {
+		return nil
+	}
+
+	tree, err := n.repository.LookupTree(n.id)
+	if err != nil {
+		return errors.Wrapf(err, "failed to find tree %s", n.id)
+	}
+	defer tree.Free()
+
+	for i := uint64(0); i < tree.EntryCount(); i++ {
+		entry := tree.EntryByIndex(i)
+		n.children[entry.Name] = &treeNode{
+			repository: n.repository,
+			id:         entry.Id,
+			mode:       entry.Filemode,
+		}
+	}
+	n.id = nil
+
+	return nil
+}
+
+// Insert adds the object at the given path. Trees inserted on an existing
+// tree are merged. Conflicting files are replaced when overwrite is true, and
+// reported as an error otherwise.
+func (n *treeNode) Insert(path string, id *git.Oid, mode git.Filemode, overwrite bool) error {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		if mode != git.FilemodeTree {
+			return fmt.Errorf("cannot replace a directory by the file %s", id)
+		}
+
+		return n.merge(id, overwrite)
+	}
+
+	if err := n.expand(); err != nil {
+		return err
+	}
+
+	parts := strings.SplitN(path, "/", 2)
+	child, ok := n.children[parts[0]]
+	if len(parts) == 2 {
+		if !ok {
+			child = newTreeNode(n.repository)
+			n.children[parts[0]] = child
+		} else if !child.isTree() {
+			if !overwrite {
+				return fmt.Errorf("conflict on %s: a file already exists", parts[0])
+			}
+			child = newTreeNode(n.repository)
+			n.children[parts[0]] = child
+		}
+
+		return errors.Wrap(child.Insert(parts[1], id, mode, overwrite), parts[0])
+	}
+
+	if !ok {
+		n.children[path] = &treeNode{
+			repository: n.repository,
+			id:         id,
+			mode:       mode,
+		}
+
+		return nil
+	}
+
+	if child.isTree() && mode == git.FilemodeTree {
+		return child.merge(id, overwrite)
+	}
+	if child.id != nil && child.id.Equal(id) && child.mode == mode {
+		return nil
+	}
+	if !overwrite {
+		return fmt.Errorf("conflict on %s: the path is provided twice", path)
+	}
+
+	n.children[path] = &treeNode{
+		repository: n.repository,
+		id:         id,
+		mode:       mode,
+	}
+
+	return nil
+}
+
+func (n *treeNode) merge(id *git.Oid, overwrite bool) error {
+	if n.isEmpty() {
+		n.id = id
+		n.children = nil
+
+		return nil
+	}
+
+	tree, err := n.repository.LookupTree(id)
+	if err != nil {
+		return errors.Wrapf(err, "failed to find tree %s", id)
+	}
+	defer tree.Free()
+
+	for i := uint64(0); i < tree.EntryCount(); i++ {
+		entry := tree.EntryByIndex(i)
+		if err := n.Insert(entry.Name, entry.Id, entry.Filemode, overwrite); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Exists tells whether an entry exists at the given path.
+func (n *treeNode) Exists(path string) (bool, error) {
+	parts := strings.SplitN(strings.Trim(path, "/"), "/", 2)
+	if err := n.expand(); err != nil {
+		return false, err
+	}
+
+	child, ok := n.children[parts[0]]
+	if !ok {
+		return false, nil
+	}
+	if len(parts) == 1 {
+		return true, nil
+	}
+	if !child.isTree() {
+		return false, nil
+	}
+
+	return child.Exists(parts[1])
+}
+
+// Remove drops the entry at the given path, if any.
+func (n *treeNode) Remove(path string) error {
+	parts := strings.SplitN(strings.Trim(path, "/"), "/", 2)
+	if err := n.expand(); err != nil {
+		return err
+	}
+
+	child, ok := n.children[parts[0]]
+	if !ok {
+		return nil
+	}
+	if len(parts) == 1 {
+		delete(n.children, parts[0])
+
+		return nil
+	}
+	if !child.isTree() {
+		return nil
+	}
+
+	return child.Remove(parts[1])
+}
+
+// Write stores the tree in the repository and returns its id, or nil when the
+// tree is empty.
+func (n *treeNode) Write() (*git.Oid, error) {
+	if n.children == nil {
+		return n.id, nil
+	}
+
+	builder, err := n.repository.TreeBuilder()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create treeBuilder")
+	}
+	defer builder.Free()
+
+	names := []string{}
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	inserted := 0
+	for _, name := range names {
+		child := n.children[name]
+		id := child.id
+		if child.isTree() {
+			if id, err = child.Write(); err != nil {
+				return nil, err
+			}
+			if id == nil {
+				continue
+			}
+		}
+		if err := builder.Insert(name, id, child.mode); err != nil {
+			return nil, errors.Wrapf(err, "failed to insert %s in tree", name)
+		}
+		inserted++
+	}
+
+	if inserted == 0 {
+		return nil, nil
+	}
+
+	return builder.Write()
+}
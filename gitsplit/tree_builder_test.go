@@ -0,0 +1,134 @@
+package gitsplit
+
+import (
+	"github.com/libgit2/git2go"
+	"testing"
+)
+
+func createTestBlob(t *testing.T, repository *git.Repository, content string) *git.Oid {
+	id, err := repository.CreateBlobFromBuffer([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// writeTestTree creates a tree holding the given files, indexed by path.
+func writeTestTree(t *testing.T, repository *git.Repository, files map[string]string) *git.Oid {
+	root := newTreeNode(repository)
+	for filePath, content := range files {
+		if err := root.Insert(filePath, createTestBlob(t, repository, content), git.FilemodeBlob, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	treeId, err := root.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return treeId
+}
+
+// readTestTree returns the content of the files of the tree, indexed by path.
+func readTestTree(t *testing.T, repository *git.Repository, treeId *git.Oid) map[string]string {
+	tree, err := repository.LookupTree(treeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Free()
+
+	files := map[string]string{}
+	err = tree.Walk(func(directory string, entry *git.TreeEntry) int {
+		if entry.Type != git.ObjectBlob {
+			return 0
+		}
+		blob, err := repository.LookupBlob(entry.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[directory+entry.Name] = string(blob.Contents())
+		blob.Free()
+
+		return 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func assertTestTree(t *testing.T, repository *git.Repository, root *treeNode, expected map[string]string) {
+	treeId, err := root.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := readTestTree(t, repository, treeId)
+	if len(files) != len(expected) {
+		t.Errorf("expected the files %v, got %v", expected, files)
+		return
+	}
+	for filePath, content := range expected {
+		if files[filePath] != content {
+			t.Errorf("expected the files %v, got %v", expected, files)
+			return
+		}
+	}
+}
+
+func TestTreeNodeInsertConflicts(t *testing.T) {
+	repository := newTestRepository(t)
+	root := newTreeNode(repository)
+
+	// Trees inserted at the same path are merged
+	if err := root.Insert("lib", writeTestTree(t, repository, map[string]string{"a": "1", "shared/b": "1"}), git.FilemodeTree, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Insert("lib", writeTestTree(t, repository, map[string]string{"c": "1", "shared/d": "1"}), git.FilemodeTree, false); err != nil {
+		t.Fatal(err)
+	}
+	// Providing the same file twice is not a conflict
+	if err := root.Insert("lib/a", createTestBlob(t, repository, "1"), git.FilemodeBlob, false); err != nil {
+		t.Fatal(err)
+	}
+	assertTestTree(t, repository, root, map[string]string{"lib/a": "1", "lib/c": "1", "lib/shared/b": "1", "lib/shared/d": "1"})
+
+	conflicts := []struct {
+		name string
+		path string
+		id   *git.Oid
+		mode git.Filemode
+	}{
+		{"another file", "lib/a", createTestBlob(t, repository, "2"), git.FilemodeBlob},
+		{"a file in place of a directory", "lib/shared", createTestBlob(t, repository, "2"), git.FilemodeBlob},
+		{"a directory in place of a file", "lib/a/b", createTestBlob(t, repository, "2"), git.FilemodeBlob},
+		{"a tree with another file", "lib", writeTestTree(t, repository, map[string]string{"a": "2"}), git.FilemodeTree},
+		{"a file in place of the root", "", createTestBlob(t, repository, "2"), git.FilemodeBlob},
+	}
+	for _, c := range conflicts {
+		if err := root.Insert(c.path, c.id, c.mode, false); err == nil {
+			t.Errorf("expected a conflict when inserting %s", c.name)
+		}
+	}
+}
+
+func TestTreeNodeInsertOverwrite(t *testing.T) {
+	repository := newTestRepository(t)
+	root := newTreeNode(repository)
+	if err := root.Insert("", writeTestTree(t, repository, map[string]string{"composer.json": "1", "bin": "1", "src/a": "1"}), git.FilemodeTree, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The overlay replaces the files of the split tree
+	overlayId := writeTestTree(t, repository, map[string]string{"composer.json": "2", "bin/console": "2", "src/b": "2"})
+	if err := root.Insert("", overlayId, git.FilemodeTree, true); err != nil {
+		t.Fatal(err)
+	}
+	assertTestTree(t, repository, root, map[string]string{"composer.json": "2", "bin/console": "2", "src/a": "1", "src/b": "2"})
+
+	if err := root.Insert("src", createTestBlob(t, repository, "3"), git.FilemodeBlob, true); err != nil {
+		t.Fatal(err)
+	}
+	assertTestTree(t, repository, root, map[string]string{"composer.json": "2", "bin/console": "2", "src": "3"})
+}
@@ -0,0 +1,209 @@
+package gitsplit
+
+import (
+	"fmt"
+	"github.com/jderusse/gitsplit/utils"
+	"github.com/libgit2/git2go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var envVarRegexp = regexp.MustCompile(`\$(\{(\w+)\}|(\w+))`)
+
+// Validate reports the problems of the configuration which can be detected
+// without fetching any repository.
+func (c *Config) Validate() utils.Errors {
+	errs := utils.Errors{}
+	reported := map[string]bool{}
+	report := func(err error) {
+		if !reported[err.Error()] {
+			reported[err.Error()] = true
+			errs = append(errs, err)
+		}
+	}
+
+	for _, err := range validatePatterns("origin", c.Origins) {
+		report(err)
+	}
+	for _, err := range validatePatterns("excluded origin", c.ExcludeOrigins) {
+		report(err)
+	}
+	if c.CacheUrl != nil {
+		for _, err := range validateEnvVars("cache_url", c.CacheUrl.url) {
+			report(err)
+		}
+	}
+	if c.ProjectUrl != nil {
+		for _, err := range validateEnvVars("project_url", c.ProjectUrl.url) {
+			report(err)
+		}
+	}
+
+	targets := map[string]string{}
+	for _, split := range c.Splits {
+		splitName := split.DisplayName()
+		for _, err := range split.validatePatterns() {
+			report(errors.Wrapf(err, "split %s", splitName))
+		}
+		for _, target := range split.Targets {
+			for _, err := range validateEnvVars("target", target) {
+				report(errors.Wrapf(err, "split %s", splitName))
+			}
+			if otherSplit, ok := targets[target]; ok {
+				report(fmt.Errorf("The target %s is used by the splits %s and %s", target, otherSplit, splitName))
+				continue
+			}
+			targets[target] = splitName
+		}
+	}
+
+	return errs
+}
+
+func (s *Split) validatePatterns() utils.Errors {
+	errs := validatePatterns("origin", s.Origins)
+
+	return append(errs, validatePatterns("excluded origin", s.ExcludeOrigins)...)
+}
+
+func validatePatterns(kind string, patterns []string) utils.Errors {
+	errs := utils.Errors{}
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(strings.TrimPrefix(pattern, "!")); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid %s %s: %s", kind, pattern, err))
+		}
+	}
+
+	return errs
+}
+
+func validateEnvVars(kind string, url string) utils.Errors {
+	errs := utils.Errors{}
+	for _, match := range envVarRegexp.FindAllStringSubmatch(url, -1) {
+		name := match[2] + match[3]
+		if _, ok := os.LookupEnv(name); !ok {
+			errs = append(errs, fmt.Errorf("The %s %s uses the unset variable %s", kind, url, name))
+		}
+	}
+
+	return errs
+}
+
+// ValidatePrefixes reports the prefixes and overlays of the splits which do
+// not exist on any of the references they are split from. The project
+// repository is only read, remote projects are not checked.
+func ValidatePrefixes(config Config) (utils.Errors, error) {
+	if !config.ProjectUrl.IsLocal() {
+		log.WithFields(log.Fields{
+		    "project": config.ProjectUrl.Url(),
+		}).Warn("Prefixes of remote projects are not checked")
+		return nil, nil
+	}
+
+	repository, err := git.OpenRepository(config.ProjectUrl.SchemelessUrl())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open project repository")
+	}
+	defer repository.Free()
+
+	references, err := getProjectReferences(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := utils.Errors{}
+	for _, split := range config.Splits {
+		// Invalid patterns are already reported by Config.Validate
+		if len(split.validatePatterns()) > 0 {
+			continue
+		}
+
+		splitName := split.DisplayName()
+		paths := []string{}
+		for _, prefix := range split.Prefixes {
+			from, _ := parsePrefix(prefix)
+			paths = append(paths, strings.Trim(from, "/"))
+		}
+		if split.Overlay != "" {
+			paths = append(paths, strings.Trim(split.Overlay, "/"))
+		}
+
+		found := map[string]bool{}
+		matched := false
+		for _, reference := range references {
+			if !split.MatchReference(reference) {
+				continue
+			}
+			matched = true
+
+			tree, err := getTree(repository, reference.Id)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read reference %s", reference.Alias)
+			}
+			for _, entryPath := range paths {
+				if _, err := tree.EntryByPath(entryPath); err == nil {
+					found[entryPath] = true
+				}
+			}
+			tree.Free()
+		}
+
+		if !matched {
+			errs = append(errs, fmt.Errorf("The split %s does not match any reference", splitName))
+			continue
+		}
+		for _, entryPath := range paths {
+			if !found[entryPath] {
+				errs = append(errs, fmt.Errorf("The path %s of the split %s does not exist on any matching reference", entryPath, splitName))
+			}
+		}
+	}
+
+	return errs, nil
+}
+
+// getProjectReferences returns the branches and tags of the project
+// repository, as the origin remote of the working space would list them.
+func getProjectReferences(repository *git.Repository) ([]Reference, error) {
+	references := []Reference{}
+	for _, ref := range []string{"heads", "tags"} {
+		iterator, err := repository.NewReferenceIteratorGlob(fmt.Sprintf("refs/%s/*", ref))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetch references")
+		}
+
+		reference, err := iterator.Next()
+		for err == nil {
+			references = append(references, Reference{
+				Alias:     strings.TrimPrefix(reference.Name(), fmt.Sprintf("refs/%s/", ref)),
+				ShortName: strings.TrimPrefix(reference.Name(), "refs/"),
+				Name:      reference.Name(),
+				Id:        reference.Target(),
+			})
+			reference, err = iterator.Next()
+		}
+		iterator.Free()
+	}
+
+	return references, nil
+}
+
+// getTree returns the tree of a commit or of a tag pointing to a commit. The
+// caller owns the returned tree.
+func getTree(repository *git.Repository, id *git.Oid) (*git.Tree, error) {
+	commitId, err := peelCommitId(repository, id)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := repository.LookupCommit(commitId)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find commit %s", commitId)
+	}
+	defer commit.Free()
+
+	return commit.Tree()
+}
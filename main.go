@@ -2,8 +2,10 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/jderusse/gitsplit/gitsplit"
 	log "github.com/sirupsen/logrus"
+	"os"
 	"strings"
 )
 
@@ -18,20 +20,106 @@ func (i *arrayFlags) Set(value string) error {
 	return nil
 }
 
+var configPath string
 var whitelistReferences arrayFlags
+var whitelistSplits arrayFlags
+var dryRun bool
+var reportPath string
+var keepGoing bool
+var jobs uint
 
 func init() {
-	flag.Var(&whitelistReferences, "ref", "References to split.")
+	flag.StringVar(&configPath, "config", ".gitsplit.yml", "Path to the configuration file.")
+	flag.Var(&whitelistReferences, "ref", "References to split, as exact names, globs or regexps starting with ^. Prefix with ! to exclude references.")
+	flag.Var(&whitelistSplits, "split", "Names of the splits to split, as exact names, globs or regexps starting with ^. Prefix with ! to exclude splits.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the split plan without pushing anything.")
+	flag.StringVar(&reportPath, "report", "", "Write a JSON report of the run into the given file.")
+	flag.BoolVar(&keepGoing, "keep-going", false, "Continue splitting other references when one fails.")
+	flag.UintVar(&jobs, "jobs", 1, "Number of references and splits to process concurrently (references of the same split are split one at a time).")
 }
 
 func handleError(err error) {
 	log.Fatal(err)
 }
 
+func writeReport(report *gitsplit.Report) {
+	if reportPath == "" {
+		return
+	}
+
+	if err := report.Dump(reportPath); err != nil {
+		log.Error(err)
+	}
+}
+
+// validate reports every problem of the configuration without splitting,
+// fetching nor pushing anything.
+func validate() {
+	config, errs := gitsplit.ValidateConfigFile(configPath)
+	if config != nil {
+		errs = append(errs, config.Validate()...)
+
+		prefixErrs, err := gitsplit.ValidatePrefixes(*config)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		errs = append(errs, prefixErrs...)
+	}
+
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
+		os.Exit(1)
+	}
+
+	log.Info("Configuration is valid")
+}
+
+// list prints the splits of the configuration, once includes and discovered
+// splits have been expanded.
+func list() {
+	config, err := gitsplit.NewConfigFromFile(configPath)
+	if err != nil {
+		handleError(err)
+	}
+
+	for _, split := range config.Splits {
+		fmt.Printf("%s\n", split.DisplayName())
+		if split.Name != "" {
+			fmt.Printf("  prefix %s\n", strings.Join(split.Prefixes, ", "))
+		}
+		for _, target := range split.Targets {
+			fmt.Printf("  -> %s\n", target)
+		}
+	}
+}
+
 func main() {
 	flag.Parse()
 
-	config, err := gitsplit.NewConfigFromFile(".gitsplit.yml")
+	// Flags can be given after the command as well
+	command := flag.Arg(0)
+	if command != "" {
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	if flag.NArg() > 0 {
+		handleError(fmt.Errorf("Unexpected argument %s", flag.Arg(0)))
+	}
+
+	switch command {
+	case "validate":
+		validate()
+		return
+	case "list":
+		list()
+		return
+	case "":
+	default:
+		handleError(fmt.Errorf("Unknown command %s. Expects validate or list", command))
+	}
+
+	config, err := gitsplit.NewConfigFromFile(configPath)
 	if err != nil {
 		handleError(err)
 	}
@@ -43,6 +131,7 @@ func main() {
 	if err != nil {
 		handleError(err)
 	}
+	workingSpace.Remotes().SetDryRun(dryRun)
 
 	cachePool, err := workingSpace.GetCachePool()
 	if err != nil {
@@ -53,13 +142,40 @@ func main() {
 	}
 
 	splitter := gitsplit.NewSplitter(*config, workingSpace, cachePool)
-	if err := splitter.Split(whitelistReferences); err != nil {
-		handleError(err)
+	splitter.SetKeepGoing(keepGoing)
+	splitter.SetJobs(jobs)
+	splitter.SetWhitelistSplits(whitelistSplits)
+	splitErr := splitter.Split(whitelistReferences)
+	if splitErr != nil && !keepGoing {
+		// Wait for the pushes in progress to know their status
+		if err := workingSpace.Remotes().Flush(); err != nil {
+			log.Error(err)
+		}
+		writeReport(splitter.Report())
+		handleError(splitErr)
+	}
+
+	if dryRun {
+		splitter.Report().Print(os.Stdout)
+		writeReport(splitter.Report())
+		if splitErr != nil {
+			handleError(splitErr)
+		}
+		return
 	}
 
 	if err := cachePool.Dump(); err != nil {
+		writeReport(splitter.Report())
 		handleError(err)
 	}
 
 	cachePool.Push()
+	err = workingSpace.Remotes().Flush()
+	writeReport(splitter.Report())
+	if err != nil {
+		handleError(err)
+	}
+	if splitErr != nil {
+		handleError(splitErr)
+	}
 }
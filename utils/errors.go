@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Errors []error
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	messages := []string{}
+	for _, err := range e {
+		messages = append(messages, "\t* "+err.Error())
+	}
+
+	return fmt.Sprintf("%d errors occurred:\n%s", len(e), strings.Join(messages, "\n"))
+}
+
+func (e Errors) ErrorOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
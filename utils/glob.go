@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// GlobToRegexp converts a glob pattern into a regexp. "*" and "?" do not
+// match "/", while "**" matches any number of directories.
+func GlobToRegexp(pattern string) string {
+	var buffer strings.Builder
+	buffer.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			buffer.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			buffer.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			buffer.WriteString("[^/]*")
+		case pattern[i] == '?':
+			buffer.WriteString("[^/]")
+		default:
+			buffer.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		}
+	}
+	buffer.WriteString("$")
+
+	return buffer.String()
+}
+
+func CompileGlob(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(GlobToRegexp(pattern))
+}
+
+func MatchGlob(pattern string, path string) bool {
+	return CompileGlob(pattern).MatchString(path)
+}
+
+func IsGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?")
+}
+
+// GlobBase returns the leading directories of the pattern which do not
+// contain any wildcard.
+func GlobBase(pattern string) string {
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if IsGlob(part) {
+			return strings.Join(parts[:i], "/")
+		}
+	}
+
+	return strings.Join(parts[:len(parts)-1], "/")
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"*.md", "README.md", true},
+		{"*.md", "docs/README.md", false},
+		{"docs/?.md", "docs/a.md", true},
+		{"docs/?.md", "docs/ab.md", false},
+		{"docs/**", "docs/api/index.md", true},
+		{"docs/**/*.md", "docs/index.md", true},
+		{"docs/**/*.md", "docs/api/v1/index.md", true},
+		{"docs/**/*.md", "src/index.md", false},
+		{"a+b.md", "a+b.md", true},
+		{"a+b.md", "aab.md", false},
+	}
+
+	for _, c := range cases {
+		if got := MatchGlob(c.pattern, c.path); got != c.expected {
+			t.Errorf("expected %s to match %s: %t, got %t", c.pattern, c.path, c.expected, got)
+		}
+	}
+}
+
+func TestGlobBase(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		expected string
+	}{
+		{"*.md", ""},
+		{"docs/*.md", "docs"},
+		{"docs/api/**/*.md", "docs/api"},
+		{"docs/*/index.md", "docs"},
+		{"docs/index.md", "docs"},
+	}
+
+	for _, c := range cases {
+		if got := GlobBase(c.pattern); got != c.expected {
+			t.Errorf("expected the base of %s to be %q, got %q", c.pattern, c.expected, got)
+		}
+	}
+}
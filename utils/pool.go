@@ -40,6 +40,17 @@ func (p *PoolResults) FirstError() error {
 	return nil
 }
 
+func (p *PoolResults) Errors() Errors {
+	errs := Errors{}
+	for _, result := range *p {
+		if err := result.Error(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
 func (p *Pool) Wait() PoolResults {
 	p.batch.QueueComplete()
 